@@ -0,0 +1,200 @@
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// javaGoldenBSIs gives the content of the golden files of the Java
+// RoaringBitmapSliceIndex format in testdata.
+//
+// testdata/GoldenFiles.java writes the same BSIs with Java
+// (RoaringBitmapSliceIndex.serialize), as described in testdata/README.md.
+// Until the files are regenerated with it, they are those written by
+// javaEncode, which follows the Java format from the values alone.
+var javaGoldenBSIs = map[string]func() *BSI{
+	"java_bsi_empty.bin": NewDefaultBSI,
+	"java_bsi.bin": func() *BSI {
+		bsi := NewBSI(999, 0)
+		for i := 0; i < 1000; i++ {
+			bsi.SetValue(uint64(3*i), int64(i*i%1000))
+		}
+		bsi.RunOptimize()
+		return bsi
+	},
+	"java_bsi_wide.bin": func() *BSI {
+		bsi := NewBSI(1<<31-1, 5)
+		for i := 0; i < 100; i++ {
+			bsi.SetValue(uint64(i)<<20, 1<<31-1-int64(i))
+		}
+		bsi.SetValue(7, 5)
+		return bsi
+	},
+}
+
+// specEncode writes the sorted values as a 32-bit bitmap in the format of
+// the RoaringFormatSpec, from the values alone rather than from the
+// containers of the roaring package. When runs is true, the values of a key
+// are written as a run container if that is not larger than an array or a
+// bitmap container, as run optimization does.
+func specEncode(t *testing.T, values []uint32, runs bool) []byte {
+	const (
+		serialCookieNoRunContainer = 12346
+		serialCookie               = 12347
+		noOffsetThreshold          = 4
+		arrayMaxSize               = 4096
+		bitmapBytes                = 8192
+	)
+	var keys []uint16
+	var lows [][]uint16
+	for _, v := range values {
+		if len(keys) == 0 || keys[len(keys)-1] != uint16(v>>16) {
+			keys = append(keys, uint16(v>>16))
+			lows = append(lows, nil)
+		}
+		lows[len(lows)-1] = append(lows[len(lows)-1], uint16(v))
+	}
+
+	// the payload of each container and whether it holds runs
+	payloads := make([][]byte, len(keys))
+	isRun := make([]bool, len(keys))
+	hasRuns := false
+	for i, low := range lows {
+		var intervals []uint16 // start and length-1 of every run
+		for j, x := range low {
+			if j > 0 && low[j-1]+1 == x {
+				intervals[len(intervals)-1]++
+			} else {
+				intervals = append(intervals, x, 0)
+			}
+		}
+		var payload bytes.Buffer
+		nruns := len(intervals) / 2
+		switch {
+		case runs && 2+4*nruns <= bitmapBytes && 2+4*nruns <= 2*len(low):
+			isRun[i], hasRuns = true, true
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, uint16(nruns)))
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, intervals))
+		case len(low) <= arrayMaxSize:
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, low))
+		default:
+			words := make([]uint64, 1<<10)
+			for _, x := range low {
+				words[x/64] |= 1 << (x % 64)
+			}
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, words))
+		}
+		payloads[i] = payload.Bytes()
+	}
+
+	var buf bytes.Buffer
+	if hasRuns {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(serialCookie|(len(keys)-1)<<16)))
+		runBitmap := make([]byte, (len(keys)+7)/8)
+		for i := range keys {
+			if isRun[i] {
+				runBitmap[i/8] |= 1 << (i % 8)
+			}
+		}
+		buf.Write(runBitmap)
+	} else {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(serialCookieNoRunContainer)))
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(len(keys))))
+	}
+	for i, key := range keys {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, []uint16{key, uint16(len(lows[i]) - 1)}))
+	}
+	if !hasRuns || len(keys) >= noOffsetThreshold {
+		offset := buf.Len() + 4*len(keys)
+		for _, payload := range payloads {
+			require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(offset)))
+			offset += len(payload)
+		}
+	}
+	for _, payload := range payloads {
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+// javaEncode writes bsi as described by RoaringBitmapSliceIndex.serialize of
+// the Java library, independently of WriteToJava and of the serialization of
+// the roaring package.
+func javaEncode(t *testing.T, bsi *BSI) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, int32(bsi.MinValue)))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, int32(bsi.MaxValue)))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, bsi.runOptimized))
+	buf.Write(specEncode(t, bsi.GetExistenceBitmap().ToArray(), bsi.runOptimized))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, int32(bsi.BitCount())))
+	for _, bm := range bsi.bA {
+		buf.Write(specEncode(t, bm.ToArray(), bsi.runOptimized))
+	}
+	return buf.Bytes()
+}
+
+func TestJavaFormatGoldenFiles(t *testing.T) {
+	for name, build := range javaGoldenBSIs {
+		expected := build()
+		data, err := ioutil.ReadFile("testdata/" + name)
+		require.NoError(t, err, name)
+
+		assert.Equal(t, javaEncode(t, expected), data, name)
+
+		var buf bytes.Buffer
+		n, err := expected.WriteToJava(&buf)
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.Equal(t, data, buf.Bytes(), name)
+
+		bsi := NewDefaultBSI()
+		n, err = bsi.ReadFromJava(bytes.NewReader(data))
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.Equal(t, expected.MinValue, bsi.MinValue, name)
+		assert.Equal(t, expected.MaxValue, bsi.MaxValue, name)
+		assert.Equal(t, expected.runOptimized, bsi.runOptimized, name)
+		assert.Equal(t, expected.BitCount(), bsi.BitCount(), name)
+		assert.True(t, expected.GetExistenceBitmap().Equals(bsi.GetExistenceBitmap()), name)
+		iter := expected.GetExistenceBitmap().Iterator()
+		for iter.HasNext() {
+			cID := uint64(iter.Next())
+			e, _ := expected.GetValue(cID)
+			a, ok := bsi.GetValue(cID)
+			assert.True(t, ok, name)
+			assert.Equal(t, e, a, name)
+		}
+	}
+}
+
+func TestJavaFormatErrors(t *testing.T) {
+	bsi := NewDefaultBSI()
+	bsi.SetValue(1, -1)
+	_, err := bsi.WriteToJava(&bytes.Buffer{})
+	assert.Error(t, err)
+
+	bsi = NewDefaultBSI()
+	bsi.SetValue(1, 1<<31)
+	_, err = bsi.WriteToJava(&bytes.Buffer{})
+	assert.Error(t, err)
+
+	data := javaEncode(t, javaGoldenBSIs["java_bsi.bin"]())
+	for _, n := range []int{0, 4, 9, 20, len(data) - 1} {
+		_, err = NewDefaultBSI().ReadFromJava(bytes.NewReader(data[:n]))
+		assert.Error(t, err, "%d bytes", n)
+	}
+
+	// a negative number of bit slices
+	empty, err := roaring.NewBitmap().ToBytes()
+	require.NoError(t, err)
+	data = append([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0}, empty...)
+	data = append(data, 0xFF, 0xFF, 0xFF, 0xFF)
+	_, err = NewDefaultBSI().ReadFromJava(bytes.NewReader(data))
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+import java.io.DataOutputStream;
+import java.io.FileOutputStream;
+import java.io.IOException;
+import java.io.PrintWriter;
+
+import org.roaringbitmap.RoaringBitmap;
+import org.roaringbitmap.bsi.RoaringBitmapSliceIndex;
+
+/**
+ * Writes the golden files of BitSliceIndexing/testdata with the Java
+ * RoaringBitmap library, from the same values as the Go tests. See README.md.
+ */
+public class GoldenFiles {
+
+  public static void main(String[] args) throws IOException {
+    String dir = args.length > 0 ? args[0] : ".";
+    writeBSI(dir);
+    writeGeneratedBy(dir);
+  }
+
+  // the values of javaGoldenBSIs in java_golden_test.go
+  static void writeBSI(String dir) throws IOException {
+    writeBSI(dir + "/java_bsi_empty.bin", new RoaringBitmapSliceIndex());
+
+    RoaringBitmapSliceIndex bsi = new RoaringBitmapSliceIndex(0, 999);
+    for (int i = 0; i < 1000; i++) {
+      bsi.setValue(3 * i, i * i % 1000);
+    }
+    bsi.runOptimize();
+    writeBSI(dir + "/java_bsi.bin", bsi);
+
+    bsi = new RoaringBitmapSliceIndex(5, Integer.MAX_VALUE);
+    for (int i = 0; i < 100; i++) {
+      bsi.setValue(i << 20, Integer.MAX_VALUE - i);
+    }
+    bsi.setValue(7, 5);
+    writeBSI(dir + "/java_bsi_wide.bin", bsi);
+  }
+
+  static void writeBSI(String path, RoaringBitmapSliceIndex bsi) throws IOException {
+    try (DataOutputStream out = new DataOutputStream(new FileOutputStream(path))) {
+      bsi.serialize(out);
+    }
+  }
+
+  // GENERATED_BY records the library and the JVM that wrote the files
+  static void writeGeneratedBy(String dir) throws IOException {
+    String version = RoaringBitmap.class.getPackage().getImplementationVersion();
+    try (PrintWriter out = new PrintWriter(dir + "/GENERATED_BY")) {
+      out.println("generator: GoldenFiles.java");
+      out.println("library: org.roaringbitmap:RoaringBitmap " + (version != null ? version : "unknown"));
+      out.println("java: " + System.getProperty("java.version"));
+    }
+  }
+}
@@ -338,6 +338,14 @@ func (ac *arrayContainer) orCardinality(a container) int {
 	panic("unsupported container type")
 }
 
+func (ac *arrayContainer) andNotCardinality(a container) int {
+	return ac.getCardinality() - ac.andCardinality(a)
+}
+
+func (ac *arrayContainer) xorCardinality(a container) int {
+	return ac.getCardinality() + a.getCardinality() - 2*ac.andCardinality(a)
+}
+
 func (ac *arrayContainer) ior(a container) container {
 	switch x := a.(type) {
 	case *arrayContainer:
@@ -387,18 +395,23 @@ func (ac *arrayContainer) iorArray(value2 *arrayContainer) container {
 	return ac
 }
 
+// iorBitmap returns the union as a new bitmap container, leaving ac
+// unchanged: the union may have more values than an array container holds.
 // Note: such code does not make practical sense, except for lazy evaluations
 func (ac *arrayContainer) iorBitmap(bc2 *bitmapContainer) container {
-	bc1 := ac.toBitmapContainer()
-	bc1.iorBitmap(bc2)
-	*ac = *newArrayContainerFromBitmap(bc1)
-	return ac
+	return bc2.orArray(ac)
 }
 
+// iorRun16 stores the union in ac, unless it has more values than an array
+// container holds, in which case it is returned as a bitmap container.
 func (ac *arrayContainer) iorRun16(rc *runContainer16) container {
 	bc1 := ac.toBitmapContainer()
 	bc2 := rc.toBitmapContainer()
-	bc1.iorBitmap(bc2)
+	result := bc1.iorBitmap(bc2)
+	if result.getCardinality() > arrayDefaultMaxSize {
+		// the union does not fit in an array container
+		return result
+	}
 	*ac = *newArrayContainerFromBitmap(bc1)
 	return ac
 }
@@ -858,6 +871,86 @@ func (ac *arrayContainer) selectInt(x uint16) int {
 	return int(ac.content[x])
 }
 
+func (ac *arrayContainer) nextValue(x uint16) int {
+	i := binarySearch(ac.content, x)
+	if i >= 0 {
+		return int(x)
+	}
+	i = -i - 1
+	if i >= len(ac.content) {
+		return -1
+	}
+	return int(ac.content[i])
+}
+
+func (ac *arrayContainer) previousValue(x uint16) int {
+	i := binarySearch(ac.content, x)
+	if i >= 0 {
+		return int(x)
+	}
+	i = -i - 1
+	if i == 0 {
+		return -1
+	}
+	return int(ac.content[i-1])
+}
+
+// nextAbsentValue relies on the fact that content[j] - j is non-decreasing,
+// and constant over a run of consecutive values: the end of the run
+// containing x can therefore be found by binary search.
+func (ac *arrayContainer) nextAbsentValue(x uint16) int {
+	i := binarySearch(ac.content, x)
+	if i < 0 {
+		return int(x)
+	}
+	offset := int(ac.content[i]) - i
+	low, high := i, len(ac.content)-1
+	for low < high {
+		mid := int(uint(low+high+1) >> 1)
+		if int(ac.content[mid])-mid == offset {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	if ac.content[low] == MaxUint16 {
+		return -1
+	}
+	return int(ac.content[low]) + 1
+}
+
+func (ac *arrayContainer) previousAbsentValue(x uint16) int {
+	i := binarySearch(ac.content, x)
+	if i < 0 {
+		return int(x)
+	}
+	offset := int(ac.content[i]) - i
+	low, high := 0, i
+	for low < high {
+		mid := int(uint(low+high) >> 1)
+		if int(ac.content[mid])-mid == offset {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	if ac.content[low] == 0 {
+		return -1
+	}
+	return int(ac.content[low]) - 1
+}
+
+func (ac *arrayContainer) rangeCardinality(start, end int) int {
+	if start >= end {
+		return 0
+	}
+	first := ac.rank(uint16(start - 1))
+	if start == 0 {
+		first = 0
+	}
+	return ac.rank(uint16(end-1)) - first
+}
+
 func (ac *arrayContainer) clone() container {
 	ptr := arrayContainer{make([]uint16, len(ac.content))}
 	copy(ptr.content, ac.content[:])
@@ -1009,3 +1102,18 @@ func (ac *arrayContainer) addOffset(x uint16) []container {
 	}
 	return []container{low, high}
 }
+
+func (ac *arrayContainer) validate() error {
+	if len(ac.content) == 0 {
+		return fmt.Errorf("array container is empty")
+	}
+	if len(ac.content) > arrayDefaultMaxSize {
+		return fmt.Errorf("array container has %d values, more than %d", len(ac.content), arrayDefaultMaxSize)
+	}
+	for i := 1; i < len(ac.content); i++ {
+		if ac.content[i-1] >= ac.content[i] {
+			return fmt.Errorf("array container values are not strictly increasing at index %d", i)
+		}
+	}
+	return nil
+}
@@ -336,6 +336,34 @@ func TestArrayContainerIand(t *testing.T) {
 	assert.EqualValues(t, 150000, r[2])
 }
 
+func TestArrayContainerIorBitmap(t *testing.T) {
+	ac := newArrayContainerRange(0, 99)
+	bc := newBitmapContainerwithRange(50, 5049)
+
+	// the union is too large for an array container
+	result := ac.iorBitmap(bc)
+	assert.IsType(t, &bitmapContainer{}, result)
+	assert.Equal(t, 5050, result.getCardinality())
+	assert.NoError(t, result.validate())
+	assert.Equal(t, 5000, bc.getCardinality(), "the argument is not modified")
+}
+
+func TestArrayContainerIorRun16(t *testing.T) {
+	// a small union stays an array container
+	ac := newArrayContainerRange(0, 99)
+	result := ac.iorRun16(newRunContainer16Range(50, 199))
+	assert.True(t, result == container(ac))
+	assert.Equal(t, 200, result.getCardinality())
+	assert.NoError(t, result.validate())
+
+	// a union with more than arrayDefaultMaxSize values is a bitmap container
+	ac = newArrayContainerRange(0, 99)
+	result = ac.iorRun16(newRunContainer16Range(50, 5049))
+	assert.IsType(t, &bitmapContainer{}, result)
+	assert.Equal(t, 5050, result.getCardinality())
+	assert.NoError(t, result.validate())
+}
+
 func TestArrayIteratorPeekNext(t *testing.T) {
 	testContainerIteratorPeekNext(t, newArrayContainer())
 }
@@ -230,6 +230,19 @@ func (bcmi *bitmapContainerManyIterator) nextMany64(hs uint64, buf []uint64) int
 	return n
 }
 
+// advanceIfNeeded advances as long as the next value is smaller than minval
+func (bcmi *bitmapContainerManyIterator) advanceIfNeeded(minval uint16) {
+	i := int(minval) / 64
+	if i < bcmi.base {
+		return
+	}
+	if i > bcmi.base {
+		bcmi.base = i
+		bcmi.bitset = bcmi.ptr.bitmap[i]
+	}
+	bcmi.bitset &= ^uint64(0) << (minval % 64)
+}
+
 func newBitmapContainerManyIterator(a *bitmapContainer) *bitmapContainerManyIterator {
 	return &bitmapContainerManyIterator{a, -1, 0}
 }
@@ -422,6 +435,30 @@ func (bc *bitmapContainer) orCardinality(a container) int {
 	panic("unsupported container type")
 }
 
+func (bc *bitmapContainer) andNotCardinality(a container) int {
+	switch x := a.(type) {
+	case *arrayContainer:
+		return bc.cardinality - bc.andArrayCardinality(x)
+	case *bitmapContainer:
+		return int(popcntMaskSlice(bc.bitmap, x.bitmap))
+	case *runContainer16:
+		return bc.cardinality - x.andBitmapContainerCardinality(bc)
+	}
+	panic("unsupported container type")
+}
+
+func (bc *bitmapContainer) xorCardinality(a container) int {
+	switch x := a.(type) {
+	case *arrayContainer:
+		return bc.cardinality + x.getCardinality() - 2*bc.andArrayCardinality(x)
+	case *bitmapContainer:
+		return int(popcntXorSlice(bc.bitmap, x.bitmap))
+	case *runContainer16:
+		return bc.cardinality + x.getCardinality() - 2*x.andBitmapContainerCardinality(bc)
+	}
+	panic("unsupported container type")
+}
+
 func (bc *bitmapContainer) ior(a container) container {
 	switch x := a.(type) {
 	case *arrayContainer:
@@ -1054,6 +1091,49 @@ func (bc *bitmapContainer) PrevSetBit(i int) int {
 	return -1
 }
 
+func (bc *bitmapContainer) nextValue(x uint16) int {
+	return bc.NextSetBit(int(x))
+}
+
+func (bc *bitmapContainer) previousValue(x uint16) int {
+	return bc.PrevSetBit(int(x))
+}
+
+func (bc *bitmapContainer) nextAbsentValue(x uint16) int {
+	i := int(x) / 64
+	w := ^bc.bitmap[i] >> (uint(x) % 64)
+	if w != 0 {
+		return int(x) + countTrailingZeros(w)
+	}
+	for i++; i < len(bc.bitmap); i++ {
+		if bc.bitmap[i] != ^uint64(0) {
+			return i*64 + countTrailingZeros(^bc.bitmap[i])
+		}
+	}
+	return -1
+}
+
+func (bc *bitmapContainer) previousAbsentValue(x uint16) int {
+	i := int(x) / 64
+	w := ^bc.bitmap[i] << (63 - uint(x)%64)
+	if w != 0 {
+		return int(x) - countLeadingZeros(w)
+	}
+	for i--; i >= 0; i-- {
+		if bc.bitmap[i] != ^uint64(0) {
+			return i*64 + 63 - countLeadingZeros(^bc.bitmap[i])
+		}
+	}
+	return -1
+}
+
+func (bc *bitmapContainer) rangeCardinality(start, end int) int {
+	if start == 0 && end == maxCapacity {
+		return bc.cardinality
+	}
+	return bc.getCardinalityInRange(uint(start), uint(end))
+}
+
 // reference the java implementation
 // https://github.com/RoaringBitmap/RoaringBitmap/blob/master/src/main/java/org/roaringbitmap/BitmapContainer.java#L875-L892
 //
@@ -1130,18 +1210,10 @@ func (bc *bitmapContainer) addOffset(x uint16) []container {
 	} else {
 		low.bitmap[b] = bc.bitmap[0] << i
 		for k := uint32(1); k < end; k++ {
-			newval := bc.bitmap[k] << i
-			if newval == 0 {
-				newval = bc.bitmap[k-1] >> (64 - i)
-			}
-			low.bitmap[b+k] = newval
+			low.bitmap[b+k] = bc.bitmap[k]<<i | bc.bitmap[k-1]>>(64-i)
 		}
 		for k := end; k < 1024; k++ {
-			newval := bc.bitmap[k] << i
-			if newval == 0 {
-				newval = bc.bitmap[k-1] >> (64 - i)
-			}
-			high.bitmap[k-end] = newval
+			high.bitmap[k-end] = bc.bitmap[k]<<i | bc.bitmap[k-1]>>(64-i)
 		}
 		high.bitmap[b] = bc.bitmap[1023] >> (64 - i)
 	}
@@ -1149,3 +1221,17 @@ func (bc *bitmapContainer) addOffset(x uint16) []container {
 	high.computeCardinality()
 	return []container{low, high}
 }
+
+func (bc *bitmapContainer) validate() error {
+	if len(bc.bitmap) != maxCapacity/64 {
+		return fmt.Errorf("bitmap container has %d words instead of %d", len(bc.bitmap), maxCapacity/64)
+	}
+	card := int(popcntSlice(bc.bitmap))
+	if card != bc.cardinality {
+		return fmt.Errorf("bitmap container cardinality is %d but %d bits are set", bc.cardinality, card)
+	}
+	if card == 0 {
+		return fmt.Errorf("bitmap container is empty")
+	}
+	return nil
+}
@@ -296,3 +296,23 @@ func TestBitmapContainerResetTo(t *testing.T) {
 		assert.True(t, dirty.toEfficientContainer().equals(run))
 	})
 }
+
+func TestBitmapContainerAddOffset(t *testing.T) {
+	bc := newBitmapContainer()
+	for i := 0; i < 20000; i++ {
+		bc.iadd(uint16(3 * i))
+	}
+	for _, offset := range []uint16{0, 1, 63, 64, 100, 30001, 65535} {
+		result := bc.addOffset(offset)
+		low, high := result[0], result[1]
+		assert.Equal(t, bc.getCardinality(), low.getCardinality()+high.getCardinality(), "offset %d", offset)
+		for i := 0; i < 20000; i++ {
+			v := 3*i + int(offset)
+			if v < 1<<16 {
+				assert.True(t, low.contains(uint16(v)), "offset %d, value %d", offset, v)
+			} else {
+				assert.True(t, high.contains(uint16(v)), "offset %d, value %d", offset, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package roaring
+
+// BitmapWriter builds a Bitmap from values that are added in ascending
+// order. Values are accumulated in a buffer for the current 16-bit key and
+// a container of the most compact type is created when the key changes,
+// so that no per-value lookup of the container is needed.
+//
+// Values may also be added out of order, at a performance cost: values
+// with the current key are still buffered, values with smaller keys are
+// added to the bitmap directly.
+//
+//	w := NewBitmapWriter()
+//	for _, v := range sortedValues {
+//		w.Add(v)
+//	}
+//	rb := w.Get()
+type BitmapWriter struct {
+	answer *Bitmap
+
+	// key is the current 16-bit key, or -1 if the buffer is empty
+	key int
+	// array holds the sorted values of the current key while
+	// there are few of them
+	array []uint16
+	// bitmap holds the values of the current key once array is not
+	// sufficient, i.e., when there are many values or when values
+	// are out of order
+	bitmap    *bitmapContainer
+	useBitmap bool
+}
+
+// NewBitmapWriter creates a new BitmapWriter writing to a new, empty bitmap.
+func NewBitmapWriter() *BitmapWriter {
+	return &BitmapWriter{answer: NewBitmap(), key: -1}
+}
+
+// Add adds the integer x to the bitmap being built.
+func (w *BitmapWriter) Add(x uint32) {
+	key := int(highbits(x))
+	if key != w.key {
+		if key < w.key {
+			w.answer.Add(x)
+			return
+		}
+		w.Flush()
+		w.key = key
+	}
+	low := lowbits(x)
+	if w.useBitmap {
+		w.bitmap.bitmap[low>>6] |= uint64(1) << (low % 64)
+		return
+	}
+	n := len(w.array)
+	if n == 0 || low > w.array[n-1] {
+		if n == arrayDefaultMaxSize {
+			w.switchToBitmap()
+			w.bitmap.bitmap[low>>6] |= uint64(1) << (low % 64)
+			return
+		}
+		w.array = append(w.array, low)
+	} else if low != w.array[n-1] {
+		w.switchToBitmap()
+		w.bitmap.bitmap[low>>6] |= uint64(1) << (low % 64)
+	}
+}
+
+// AddMany adds all the integers of dat to the bitmap being built.
+func (w *BitmapWriter) AddMany(dat []uint32) {
+	for _, x := range dat {
+		w.Add(x)
+	}
+}
+
+// AddRange adds the integers in [rangeStart, rangeEnd) to the bitmap being built.
+func (w *BitmapWriter) AddRange(rangeStart, rangeEnd uint64) {
+	if rangeStart >= rangeEnd {
+		return
+	}
+	if rangeEnd-1 > MaxUint32 {
+		panic("rangeEnd-1 > MaxUint32")
+	}
+	hbStart := int(highbits(uint32(rangeStart)))
+	if hbStart < w.key {
+		// out of order, fall back to the bitmap itself for the keys before the current one
+		end := uint64(w.key) << 16
+		if rangeEnd < end {
+			end = rangeEnd
+		}
+		w.answer.AddRange(rangeStart, end)
+		rangeStart = end
+		if rangeStart >= rangeEnd {
+			return
+		}
+	}
+
+	for start := rangeStart; start < rangeEnd; {
+		key := int(start >> 16)
+		last := uint64(key)<<16 | maxLowBit
+		if rangeEnd-1 < last {
+			last = rangeEnd - 1
+		}
+		if key != w.key {
+			w.Flush()
+			w.key = key
+		}
+		lo, hi := int(start&maxLowBit), int(last&maxLowBit)
+
+		n := len(w.array)
+		if !w.useBitmap && (n == 0 || int(w.array[n-1]) < lo) && n+hi-lo+1 <= arrayDefaultMaxSize {
+			for v := lo; v <= hi; v++ {
+				w.array = append(w.array, uint16(v))
+			}
+		} else {
+			if !w.useBitmap {
+				w.switchToBitmap()
+			}
+			setBitmapRange(w.bitmap.bitmap, lo, hi+1)
+		}
+		start = last + 1
+	}
+}
+
+func (w *BitmapWriter) switchToBitmap() {
+	if w.bitmap == nil {
+		w.bitmap = newBitmapContainer()
+	}
+	for _, v := range w.array {
+		w.bitmap.bitmap[v>>6] |= uint64(1) << (v % 64)
+	}
+	w.array = w.array[:0]
+	w.useBitmap = true
+}
+
+// Flush adds the buffered values to the bitmap. It is called automatically
+// when needed, and only has to be called explicitly before accessing the
+// bitmap returned by Get while values are still being added.
+func (w *BitmapWriter) Flush() {
+	if w.key < 0 {
+		return
+	}
+	var c container
+	if w.useBitmap {
+		w.bitmap.computeCardinality()
+		c = w.bitmap.toEfficientContainer()
+		if c == w.bitmap {
+			// the bitmap now belongs to the answer
+			w.bitmap = nil
+		} else {
+			for i := range w.bitmap.bitmap {
+				w.bitmap.bitmap[i] = 0
+			}
+		}
+		w.useBitmap = false
+	} else if len(w.array) > 0 {
+		ac := &arrayContainer{content: make([]uint16, len(w.array))}
+		copy(ac.content, w.array)
+		c = ac.toEfficientContainer()
+		w.array = w.array[:0]
+	}
+	if c != nil && c.getCardinality() > 0 {
+		ra := &w.answer.highlowcontainer
+		i := ra.getIndex(uint16(w.key))
+		if i >= 0 {
+			ra.setContainerAtIndex(i, ra.getWritableContainerAtIndex(i).ior(c))
+		} else if -i-1 == ra.size() {
+			ra.appendContainer(uint16(w.key), c, false)
+		} else {
+			ra.insertNewKeyValueAt(-i-1, uint16(w.key), c)
+		}
+	}
+	w.key = -1
+}
+
+// Get flushes the buffered values and returns the bitmap being built.
+// The writer may still be used afterwards, in which case it keeps adding
+// values to the same bitmap.
+func (w *BitmapWriter) Get() *Bitmap {
+	w.Flush()
+	return w.answer
+}
+
+// Reset discards the buffered values and makes the writer build a new,
+// empty bitmap. Previously returned bitmaps are not affected.
+func (w *BitmapWriter) Reset() {
+	w.answer = NewBitmap()
+	w.key = -1
+	w.array = w.array[:0]
+	if w.useBitmap {
+		for i := range w.bitmap.bitmap {
+			w.bitmap.bitmap[i] = 0
+		}
+		w.useBitmap = false
+	}
+}
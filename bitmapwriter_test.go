@@ -0,0 +1,116 @@
+package roaring
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitmapWriterSorted(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, density := range []int{1, 5, 50, 2000} {
+		expected := NewBitmap()
+		w := NewBitmapWriter()
+		x := uint32(0)
+		for i := 0; i < 100000; i++ {
+			x += uint32(1 + r.Intn(density))
+			expected.Add(x)
+			w.Add(x)
+		}
+		rb := w.Get()
+		assert.True(t, expected.Equals(rb))
+		assert.Equal(t, expected.GetCardinality(), rb.GetCardinality())
+		assert.Equal(t, expected.ToArray(), rb.ToArray())
+	}
+}
+
+func TestBitmapWriterPicksContainerType(t *testing.T) {
+	w := NewBitmapWriter()
+	// array
+	w.AddMany([]uint32{1, 5, 9})
+	// bitmap
+	for i := uint32(0); i < 10000; i++ {
+		w.Add(1<<16 + 6*i)
+	}
+	// run, from single values
+	for i := uint32(0); i < 20000; i++ {
+		w.Add(2<<16 + i)
+	}
+	// run, from a range
+	w.AddRange(3<<16, 5<<16+10)
+	rb := w.Get()
+
+	stats := rb.Stats()
+	assert.EqualValues(t, 1, stats.ArrayContainers)
+	assert.EqualValues(t, 1, stats.BitmapContainers)
+	assert.EqualValues(t, 4, stats.RunContainers)
+	assert.EqualValues(t, 3+10000+20000+2<<16+10, rb.GetCardinality())
+}
+
+func TestBitmapWriterOutOfOrder(t *testing.T) {
+	values := []uint32{10, 5, 5, 1 << 20, 1<<20 - 1, 3, 1<<20 + 1, 7 << 16, 70, 1 << 20}
+	w := NewBitmapWriter()
+	w.AddMany(values)
+	w.AddRange(100, 1<<20+200)
+	w.AddRange(50, 60)
+
+	expected := BitmapOf(values...)
+	expected.AddRange(100, 1<<20+200)
+	expected.AddRange(50, 60)
+	assert.True(t, expected.Equals(w.Get()))
+}
+
+func TestBitmapWriterRanges(t *testing.T) {
+	w := NewBitmapWriter()
+	w.Add(3)
+	w.AddRange(10, 20)
+	w.AddRange(15, 30)
+	w.Add(25)
+	w.AddRange(1<<16-2, 1<<16+2)
+	w.AddRange(MaxUint32-1, MaxRange)
+
+	expected := BitmapOf(3)
+	expected.AddRange(10, 30)
+	expected.AddRange(1<<16-2, 1<<16+2)
+	expected.AddRange(MaxUint32-1, MaxRange)
+	assert.True(t, expected.Equals(w.Get()))
+}
+
+func TestBitmapWriterReuse(t *testing.T) {
+	w := NewBitmapWriter()
+	for i := uint32(0); i < 10000; i++ {
+		w.Add(3 * i)
+	}
+	first := w.Get()
+	w.Reset()
+	w.AddMany([]uint32{1, 2, 3})
+	second := w.Get()
+
+	assert.EqualValues(t, 10000, first.GetCardinality())
+	assert.True(t, BitmapOf(1, 2, 3).Equals(second))
+
+	// values added after Get go to the same bitmap
+	w.Add(1 << 20)
+	assert.True(t, BitmapOf(1, 2, 3, 1<<20).Equals(w.Get()))
+}
+
+func BenchmarkBitmapWriter(b *testing.B) {
+	values := make([]uint32, 1000000)
+	for i := range values {
+		values[i] = uint32(i * 3)
+	}
+	b.Run("writer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			w := NewBitmapWriter()
+			w.AddMany(values)
+			w.Get()
+		}
+	})
+	b.Run("addmany", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rb := NewBitmap()
+			rb.AddMany(values)
+		}
+	})
+}
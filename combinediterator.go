@@ -0,0 +1,218 @@
+package roaring
+
+import "container/heap"
+
+// AndIterator returns an iterator over the intersection of the values of
+// the given iterators, in sorted order. The intersection is computed lazily
+// as the result is consumed, by leapfrogging the iterators with
+// AdvanceIfNeeded, so that stopping early saves the remaining work:
+//
+//	it := AndIterator(rb1.Iterator(), rb2.Iterator(), rb3.Iterator())
+//	for i := 0; i < 100 && it.HasNext(); i++ {
+//		page = append(page, it.Next())
+//	}
+//
+// The given iterators are consumed and should not be used afterwards.
+// The intersection of no iterator is empty.
+func AndIterator(iterators ...IntPeekable) IntPeekable {
+	ai := &andIterator{iterators: iterators}
+	ai.seek()
+	return ai
+}
+
+type andIterator struct {
+	iterators []IntPeekable
+	hasNext   bool
+	next      uint32
+}
+
+// seek finds the smallest value that is the next value of all the iterators.
+func (ai *andIterator) seek() {
+	ai.hasNext = false
+	n := len(ai.iterators)
+	if n == 0 || !ai.iterators[0].HasNext() {
+		return
+	}
+	candidate := ai.iterators[0].PeekNext()
+	matched := 1
+	for i := 1 % n; matched < n; i = (i + 1) % n {
+		it := ai.iterators[i]
+		it.AdvanceIfNeeded(candidate)
+		if !it.HasNext() {
+			return
+		}
+		if v := it.PeekNext(); v == candidate {
+			matched++
+		} else {
+			candidate = v
+			matched = 1
+		}
+	}
+	ai.next = candidate
+	ai.hasNext = true
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (ai *andIterator) HasNext() bool {
+	return ai.hasNext
+}
+
+// Next returns the next integer
+func (ai *andIterator) Next() uint32 {
+	x := ai.next
+	for _, it := range ai.iterators {
+		it.Next()
+	}
+	ai.seek()
+	return x
+}
+
+// PeekNext peeks the next value without advancing the iterator
+func (ai *andIterator) PeekNext() uint32 {
+	return ai.next
+}
+
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (ai *andIterator) AdvanceIfNeeded(minval uint32) {
+	if !ai.hasNext || ai.next >= minval {
+		return
+	}
+	for _, it := range ai.iterators {
+		it.AdvanceIfNeeded(minval)
+	}
+	ai.seek()
+}
+
+// OrIterator returns an iterator over the union of the values of the given
+// iterators, in sorted order and without duplicates. The union is computed
+// lazily as the result is consumed, using a heap of the iterators ordered
+// by their next value.
+//
+// The given iterators are consumed and should not be used afterwards.
+func OrIterator(iterators ...IntPeekable) IntPeekable {
+	oi := &orIterator{}
+	for _, it := range iterators {
+		if it.HasNext() {
+			oi.heap = append(oi.heap, it)
+		}
+	}
+	heap.Init(&oi.heap)
+	return oi
+}
+
+// peekableHeap is a min-heap of non-empty iterators ordered by their next value.
+type peekableHeap []IntPeekable
+
+func (h peekableHeap) Len() int            { return len(h) }
+func (h peekableHeap) Less(i, j int) bool  { return h[i].PeekNext() < h[j].PeekNext() }
+func (h peekableHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *peekableHeap) Push(x interface{}) { *h = append(*h, x.(IntPeekable)) }
+
+func (h *peekableHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
+
+type orIterator struct {
+	heap peekableHeap
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (oi *orIterator) HasNext() bool {
+	return len(oi.heap) > 0
+}
+
+// Next returns the next integer
+func (oi *orIterator) Next() uint32 {
+	x := oi.heap[0].PeekNext()
+	for len(oi.heap) > 0 && oi.heap[0].PeekNext() == x {
+		oi.heap[0].Next()
+		oi.fixTop()
+	}
+	return x
+}
+
+// fixTop restores the heap after the top iterator was advanced.
+func (oi *orIterator) fixTop() {
+	if oi.heap[0].HasNext() {
+		heap.Fix(&oi.heap, 0)
+	} else {
+		heap.Pop(&oi.heap)
+	}
+}
+
+// PeekNext peeks the next value without advancing the iterator
+func (oi *orIterator) PeekNext() uint32 {
+	return oi.heap[0].PeekNext()
+}
+
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (oi *orIterator) AdvanceIfNeeded(minval uint32) {
+	for len(oi.heap) > 0 && oi.heap[0].PeekNext() < minval {
+		oi.heap[0].AdvanceIfNeeded(minval)
+		oi.fixTop()
+	}
+}
+
+// AndNotIterator returns an iterator over the values of it that are not
+// values of any of the excluded iterators, in sorted order. The difference
+// is computed lazily as the result is consumed.
+//
+// The given iterators are consumed and should not be used afterwards.
+func AndNotIterator(it IntPeekable, excluded ...IntPeekable) IntPeekable {
+	ani := &andNotIterator{it: it}
+	switch len(excluded) {
+	case 0:
+		return it
+	case 1:
+		ani.excluded = excluded[0]
+	default:
+		ani.excluded = OrIterator(excluded...)
+	}
+	ani.seek()
+	return ani
+}
+
+type andNotIterator struct {
+	it       IntPeekable
+	excluded IntPeekable
+}
+
+// seek skips the next values of it that are excluded.
+func (ani *andNotIterator) seek() {
+	for ani.it.HasNext() {
+		v := ani.it.PeekNext()
+		ani.excluded.AdvanceIfNeeded(v)
+		if !ani.excluded.HasNext() || ani.excluded.PeekNext() != v {
+			return
+		}
+		ani.it.Next()
+	}
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (ani *andNotIterator) HasNext() bool {
+	return ani.it.HasNext()
+}
+
+// Next returns the next integer
+func (ani *andNotIterator) Next() uint32 {
+	x := ani.it.Next()
+	ani.seek()
+	return x
+}
+
+// PeekNext peeks the next value without advancing the iterator
+func (ani *andNotIterator) PeekNext() uint32 {
+	return ani.it.PeekNext()
+}
+
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (ani *andNotIterator) AdvanceIfNeeded(minval uint32) {
+	ani.it.AdvanceIfNeeded(minval)
+	ani.seek()
+}
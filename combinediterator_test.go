@@ -0,0 +1,135 @@
+package roaring
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func iteratorValues(it IntIterable) []uint32 {
+	values := []uint32{}
+	for it.HasNext() {
+		values = append(values, it.Next())
+	}
+	return values
+}
+
+func iterators(bitmaps ...*Bitmap) []IntPeekable {
+	its := make([]IntPeekable, len(bitmaps))
+	for i, rb := range bitmaps {
+		its[i] = rb.Iterator()
+	}
+	return its
+}
+
+func combinedIteratorTestBitmaps(r *rand.Rand) []*Bitmap {
+	bitmaps := make([]*Bitmap, 1+r.Intn(4))
+	for i := range bitmaps {
+		bitmaps[i] = NewBitmap()
+		for j := 0; j < r.Intn(50000); j++ {
+			bitmaps[i].Add(uint32(r.Intn(1 << 19)))
+		}
+		if r.Intn(2) == 0 {
+			start := uint64(r.Intn(1 << 19))
+			bitmaps[i].AddRange(start, start+uint64(r.Intn(1<<17)))
+		}
+		if r.Intn(3) == 0 {
+			bitmaps[i].Add(MaxUint32)
+		}
+	}
+	return bitmaps
+}
+
+func TestCombinedIterators(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+	for trial := 0; trial < 50; trial++ {
+		bitmaps := combinedIteratorTestBitmaps(r)
+
+		and := bitmaps[0].Clone()
+		or := NewBitmap()
+		for _, rb := range bitmaps {
+			and.And(rb)
+			or.Or(rb)
+		}
+		andNot := bitmaps[0].Clone()
+		for _, rb := range bitmaps[1:] {
+			andNot.AndNot(rb)
+		}
+
+		assert.Equal(t, and.ToArray(), iteratorValues(AndIterator(iterators(bitmaps...)...)))
+		assert.Equal(t, or.ToArray(), iteratorValues(OrIterator(iterators(bitmaps...)...)))
+		its := iterators(bitmaps...)
+		assert.Equal(t, andNot.ToArray(), iteratorValues(AndNotIterator(its[0], its[1:]...)))
+	}
+}
+
+func TestCombinedIteratorsAdvanceIfNeeded(t *testing.T) {
+	r := rand.New(rand.NewSource(14))
+	for trial := 0; trial < 20; trial++ {
+		bitmaps := combinedIteratorTestBitmaps(r)
+		expected := And(bitmaps[0], FastOr(bitmaps...))
+		expected.AndNot(bitmaps[len(bitmaps)-1])
+
+		it := AndNotIterator(
+			AndIterator(bitmaps[0].Iterator(), OrIterator(iterators(bitmaps...)...)),
+			bitmaps[len(bitmaps)-1].Iterator())
+
+		minval := uint32(0)
+		for it.HasNext() {
+			minval += uint32(r.Intn(5000))
+			it.AdvanceIfNeeded(minval)
+			next := expected.NextValue(minval)
+			if next < 0 {
+				assert.False(t, it.HasNext())
+				break
+			}
+			assert.True(t, it.HasNext())
+			assert.EqualValues(t, next, it.PeekNext())
+			assert.EqualValues(t, next, it.Next())
+			minval = uint32(next)
+		}
+	}
+}
+
+func TestCombinedIteratorsEdgeCases(t *testing.T) {
+	rb := BitmapOf(1, 2, 3, MaxUint32)
+	empty := NewBitmap()
+
+	assert.False(t, AndIterator().HasNext())
+	assert.False(t, OrIterator().HasNext())
+	assert.False(t, AndIterator(rb.Iterator(), empty.Iterator()).HasNext())
+	assert.Equal(t, rb.ToArray(), iteratorValues(AndIterator(rb.Iterator())))
+	assert.Equal(t, rb.ToArray(), iteratorValues(AndIterator(rb.Iterator(), rb.Iterator())))
+	assert.Equal(t, rb.ToArray(), iteratorValues(OrIterator(rb.Iterator(), empty.Iterator(), rb.Iterator())))
+	assert.Equal(t, rb.ToArray(), iteratorValues(AndNotIterator(rb.Iterator())))
+	assert.Equal(t, []uint32{}, iteratorValues(AndNotIterator(rb.Iterator(), rb.Iterator())))
+	assert.Equal(t, []uint32{2, 3}, iteratorValues(AndNotIterator(rb.Iterator(), BitmapOf(1, MaxUint32).Iterator())))
+
+	it := AndIterator(rb.Iterator(), BitmapOf(2, 3, MaxUint32).Iterator())
+	it.AdvanceIfNeeded(3)
+	assert.EqualValues(t, 3, it.Next())
+	it.AdvanceIfNeeded(2)
+	assert.EqualValues(t, MaxUint32, it.PeekNext())
+	it.AdvanceIfNeeded(MaxUint32)
+	assert.EqualValues(t, MaxUint32, it.Next())
+	assert.False(t, it.HasNext())
+}
+
+func TestAndIteratorFirstPage(t *testing.T) {
+	rb1 := NewBitmap()
+	rb1.AddRange(0, 10000000)
+	rb2 := NewBitmap()
+	for i := uint64(0); i < 10000000; i += 7 {
+		rb2.Add(uint32(i))
+	}
+
+	it := AndIterator(rb1.Iterator(), rb2.Iterator())
+	page := []uint32{}
+	for len(page) < 100 && it.HasNext() {
+		page = append(page, it.Next())
+	}
+	assert.Len(t, page, 100)
+	assert.EqualValues(t, 0, page[0])
+	assert.EqualValues(t, 99*7, page[99])
+}
@@ -0,0 +1,434 @@
+// Package expr evaluates boolean expressions over roaring bitmaps.
+//
+// An expression is a tree whose leaves are bitmaps and whose inner nodes are
+// intersections, unions and negations:
+//
+//	e := expr.And(expr.Bitmap(a), expr.Or(expr.Bitmap(b), expr.Bitmap(c)), expr.Not(expr.Bitmap(d)))
+//	result := e.Evaluate()
+//
+// Expressions are evaluated one container key (the high 16 bits of the
+// values) at a time. Before a key is evaluated, the keys which cannot hold a
+// value of the expression are skipped: an intersection only visits the keys
+// shared by all of its operands which are not negated, and a union the keys
+// of any of its operands. Each key is then evaluated top-down against the
+// values of that key which can still be part of the result, so that no
+// intermediate bitmap spans more than one container. The operands of an
+// intersection are evaluated from the cheapest to the most expensive one
+// and evaluation of the key stops as soon as the intersection is empty.
+// Negations in an intersection become differences (AndNot) against the
+// other operands.
+//
+// Evaluate assembles the result key by key, Cardinality only counts the
+// values of each key, and Iterator evaluates a key when the values of the
+// previous ones have been consumed.
+//
+// Expressions only read their bitmaps, which must not be modified while an
+// expression is evaluated.
+package expr
+
+import (
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+type kind int
+
+const (
+	leafKind kind = iota
+	andKind
+	orKind
+	notKind
+)
+
+// universe is the number of values of the 32-bit range.
+const universe = roaring.MaxRange
+
+// Expr is a node of a boolean expression over bitmaps.
+type Expr struct {
+	kind     kind
+	bitmap   *roaring.Bitmap
+	children []*Expr
+}
+
+// Bitmap returns an expression evaluating to the values of bm.
+func Bitmap(bm *roaring.Bitmap) *Expr {
+	return &Expr{kind: leafKind, bitmap: bm}
+}
+
+// Bitmaps returns one expression per bitmap, as a convenience for And and Or.
+func Bitmaps(bitmaps ...*roaring.Bitmap) []*Expr {
+	exprs := make([]*Expr, len(bitmaps))
+	for i, bm := range bitmaps {
+		exprs[i] = Bitmap(bm)
+	}
+	return exprs
+}
+
+// And returns the intersection of the given expressions. The intersection
+// of no expression is the whole 32-bit range.
+func And(exprs ...*Expr) *Expr {
+	return &Expr{kind: andKind, children: exprs}
+}
+
+// Or returns the union of the given expressions. The union of no expression
+// is empty.
+func Or(exprs ...*Expr) *Expr {
+	return &Expr{kind: orKind, children: exprs}
+}
+
+// Not returns the complement of e within the 32-bit range.
+func Not(e *Expr) *Expr {
+	return &Expr{kind: notKind, children: []*Expr{e}}
+}
+
+// AndNot returns the values of e that are not in any of the excluded expressions.
+func AndNot(e *Expr, excluded ...*Expr) *Expr {
+	children := make([]*Expr, 0, len(excluded)+1)
+	children = append(children, e)
+	for _, x := range excluded {
+		children = append(children, Not(x))
+	}
+	return And(children...)
+}
+
+// Evaluate computes the values of the expression. The returned bitmap is
+// owned by the caller.
+func (e *Expr) Evaluate() *roaring.Bitmap {
+	p := e.plan()
+	bm := roaring.NewBitmap()
+	for key := p.nextKey(0); key < keyCount; key = p.nextKey(key + 1) {
+		// the values of key are appended after those of the previous keys
+		bm.Or(p.eval(keyFilter(key)))
+	}
+	return bm
+}
+
+// Cardinality returns the number of values of the expression. The last
+// operation of the evaluation of each key is replaced by its cardinality
+// counterpart when possible, so that the result itself is not built.
+func (e *Expr) Cardinality() uint64 {
+	p := e.plan()
+	card := uint64(0)
+	for key := p.nextKey(0); key < keyCount; key = p.nextKey(key + 1) {
+		card += p.cardinality(keyFilter(key))
+	}
+	return card
+}
+
+// IsEmpty returns true if the expression has no value. Evaluation stops at
+// the first key with a value.
+func (e *Expr) IsEmpty() bool {
+	p := e.plan()
+	for key := p.nextKey(0); key < keyCount; key = p.nextKey(key + 1) {
+		if !p.eval(keyFilter(key)).IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Iterator returns an iterator over the values of the expression, in
+// increasing order. The expression is evaluated one key at a time as the
+// iterator is consumed, and AdvanceIfNeeded skips the evaluation of the
+// keys it jumps over.
+func (e *Expr) Iterator() roaring.IntPeekable {
+	return &iterator{plan: e.plan(), values: roaring.NewBitmap().Iterator()}
+}
+
+// estimate returns an upper bound of the cardinality of e, used to order
+// the operands of intersections.
+func (e *Expr) estimate() uint64 {
+	switch e.kind {
+	case leafKind:
+		return e.bitmap.GetCardinality()
+	case andKind:
+		est := uint64(universe)
+		for _, c := range e.children {
+			if c.kind == notKind {
+				continue
+			}
+			if ce := c.estimate(); ce < est {
+				est = ce
+			}
+		}
+		return est
+	case orKind:
+		est := uint64(0)
+		for _, c := range e.children {
+			est += c.estimate()
+			if est >= universe {
+				return universe
+			}
+		}
+		return est
+	}
+	return universe
+}
+
+// split separates the negated operands of an intersection from the others
+// and sorts the latter by increasing estimated cardinality.
+func (e *Expr) split() (positives, negatives []*Expr) {
+	for _, c := range e.children {
+		if c.kind == notKind {
+			negatives = append(negatives, c.children[0])
+		} else {
+			positives = append(positives, c)
+		}
+	}
+	estimates := make([]uint64, len(positives))
+	for i, c := range positives {
+		estimates[i] = c.estimate()
+	}
+	sort.Sort(byEstimate{positives, estimates})
+	return positives, negatives
+}
+
+type byEstimate struct {
+	exprs     []*Expr
+	estimates []uint64
+}
+
+func (b byEstimate) Len() int           { return len(b.exprs) }
+func (b byEstimate) Less(i, j int) bool { return b.estimates[i] < b.estimates[j] }
+func (b byEstimate) Swap(i, j int) {
+	b.exprs[i], b.exprs[j] = b.exprs[j], b.exprs[i]
+	b.estimates[i], b.estimates[j] = b.estimates[j], b.estimates[i]
+}
+
+// plan is an expression prepared for evaluation: the operands of the
+// intersections are split and ordered once, instead of once per key.
+type plan struct {
+	kind   kind
+	bitmap *roaring.Bitmap
+	// children are the operands of the node; for an intersection, they are
+	// the operands which are not negated, by increasing estimated cardinality
+	children []*plan
+	// negatives are the negated operands of an intersection
+	negatives []*plan
+}
+
+func (e *Expr) plan() *plan {
+	p := &plan{kind: e.kind, bitmap: e.bitmap}
+	children := e.children
+	if e.kind == andKind {
+		var negatives []*Expr
+		children, negatives = e.split()
+		p.negatives = plans(negatives)
+	}
+	p.children = plans(children)
+	return p
+}
+
+func plans(exprs []*Expr) []*plan {
+	if len(exprs) == 0 {
+		return nil
+	}
+	plans := make([]*plan, len(exprs))
+	for i, e := range exprs {
+		plans[i] = e.plan()
+	}
+	return plans
+}
+
+// keyCount is the number of 16-bit keys, and the key returned by nextKey
+// when no key remains.
+const keyCount = 1 << 16
+
+// keyFilter returns a bitmap holding all the values of key.
+func keyFilter(key int) *roaring.Bitmap {
+	bm := roaring.NewBitmap()
+	bm.AddRange(uint64(key)<<16, uint64(key+1)<<16)
+	return bm
+}
+
+// nextKey returns the smallest key greater than or equal to key for which
+// the node may have values, or keyCount. Keys before it have no value, so
+// that they need not be evaluated.
+func (p *plan) nextKey(key int) int {
+	if key >= keyCount {
+		return keyCount
+	}
+	switch p.kind {
+	case leafKind:
+		v := p.bitmap.NextValue(uint32(key) << 16)
+		if v < 0 {
+			return keyCount
+		}
+		return int(v >> 16)
+
+	case andKind:
+		// leapfrog the positive operands until they agree on a key
+		for {
+			moved := false
+			for _, c := range p.children {
+				next := c.nextKey(key)
+				if next == keyCount {
+					return keyCount
+				}
+				if next != key {
+					key, moved = next, true
+				}
+			}
+			if !moved {
+				return key
+			}
+		}
+
+	case orKind:
+		next := keyCount
+		for _, c := range p.children {
+			if k := c.nextKey(key); k < next {
+				next = k
+				if next == key {
+					break
+				}
+			}
+		}
+		return next
+	}
+	// a complement has values in almost every key
+	return key
+}
+
+// eval computes the values of the node that are in filter, which holds
+// values of a single key. The returned bitmap may be filter itself and must
+// not be modified.
+func (p *plan) eval(filter *roaring.Bitmap) *roaring.Bitmap {
+	switch p.kind {
+	case leafKind:
+		return roaring.And(filter, p.bitmap)
+
+	case andKind:
+		cur := filter
+		for _, c := range p.children {
+			cur = c.eval(cur)
+			if cur.IsEmpty() {
+				return cur
+			}
+		}
+		for _, c := range p.negatives {
+			excluded := c.eval(cur)
+			if excluded.IsEmpty() {
+				continue
+			}
+			cur = roaring.AndNot(cur, excluded)
+			if cur.IsEmpty() {
+				break
+			}
+		}
+		return cur
+
+	case orKind:
+		if leaves := p.leaves(); leaves != nil {
+			bm := filter.Clone()
+			bm.AndAny(leaves...)
+			return bm
+		}
+		bm := roaring.NewBitmap()
+		for _, c := range p.children {
+			bm.Or(c.eval(filter))
+		}
+		return bm
+
+	case notKind:
+		return roaring.AndNot(filter, p.children[0].eval(filter))
+	}
+	panic("unknown expression kind")
+}
+
+// leaves returns the bitmaps of the children of p if they are all leaves.
+func (p *plan) leaves() []*roaring.Bitmap {
+	if len(p.children) == 0 {
+		return nil
+	}
+	leaves := make([]*roaring.Bitmap, len(p.children))
+	for i, c := range p.children {
+		if c.kind != leafKind {
+			return nil
+		}
+		leaves[i] = c.bitmap
+	}
+	return leaves
+}
+
+// cardinality returns the number of values of the node that are in filter,
+// which holds values of a single key.
+func (p *plan) cardinality(filter *roaring.Bitmap) uint64 {
+	switch p.kind {
+	case leafKind:
+		return filter.AndCardinality(p.bitmap)
+
+	case andKind:
+		if len(p.negatives) > 0 || len(p.children) == 0 {
+			return p.eval(filter).GetCardinality()
+		}
+		cur := filter
+		last := len(p.children) - 1
+		for _, c := range p.children[:last] {
+			cur = c.eval(cur)
+			if cur.IsEmpty() {
+				return 0
+			}
+		}
+		return p.children[last].cardinality(cur)
+
+	case orKind:
+		return p.eval(filter).GetCardinality()
+
+	case notKind:
+		return filter.GetCardinality() - p.children[0].cardinality(filter)
+	}
+	panic("unknown expression kind")
+}
+
+// iterator iterates over the values of a plan, evaluating one key at a time.
+type iterator struct {
+	plan *plan
+	// key is the next key to evaluate
+	key int
+	// values iterates over the values of the last evaluated key
+	values roaring.IntPeekable
+}
+
+// fill evaluates keys until one has values or no key remains.
+func (it *iterator) fill() {
+	for !it.values.HasNext() && it.key < keyCount {
+		key := it.plan.nextKey(it.key)
+		if key == keyCount {
+			it.key = keyCount
+			return
+		}
+		it.values = it.plan.eval(keyFilter(key)).Iterator()
+		it.key = key + 1
+	}
+}
+
+// HasNext returns true if there are more integers to iterate over
+func (it *iterator) HasNext() bool {
+	it.fill()
+	return it.values.HasNext()
+}
+
+// Next returns the next integer
+func (it *iterator) Next() uint32 {
+	it.fill()
+	return it.values.Next()
+}
+
+// PeekNext peeks the next value without advancing the iterator
+func (it *iterator) PeekNext() uint32 {
+	it.fill()
+	return it.values.PeekNext()
+}
+
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (it *iterator) AdvanceIfNeeded(minval uint32) {
+	if key := int(minval >> 16); key >= it.key {
+		// the keys before that of minval are not evaluated
+		it.key = key
+		it.values = roaring.NewBitmap().Iterator()
+		it.fill()
+	}
+	it.values.AdvanceIfNeeded(minval)
+}
@@ -0,0 +1,232 @@
+package expr
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+)
+
+// values of the test bitmaps are below testRange
+const testRange = 1 << 18
+
+func randomBitmap(r *rand.Rand) *roaring.Bitmap {
+	bm := roaring.NewBitmap()
+	switch r.Intn(4) {
+	case 0:
+		for i := 0; i < r.Intn(1000); i++ {
+			bm.Add(uint32(r.Intn(testRange)))
+		}
+	case 1:
+		for i := 0; i < 50000; i++ {
+			bm.Add(uint32(r.Intn(testRange)))
+		}
+	case 2:
+		start := uint64(r.Intn(testRange))
+		bm.AddRange(start, start+uint64(r.Intn(testRange-int(start))))
+	}
+	return bm
+}
+
+func randomExpr(r *rand.Rand, depth int) *Expr {
+	if depth == 0 || r.Intn(4) == 0 {
+		return Bitmap(randomBitmap(r))
+	}
+	children := make([]*Expr, r.Intn(4))
+	for i := range children {
+		children[i] = randomExpr(r, depth-1)
+	}
+	switch r.Intn(4) {
+	case 0:
+		return Or(children...)
+	case 1:
+		return Not(randomExpr(r, depth-1))
+	case 2:
+		return AndNot(randomExpr(r, depth-1), children...)
+	}
+	return And(children...)
+}
+
+// contains evaluates e for a single value, as a reference implementation.
+func contains(e *Expr, x uint32) bool {
+	switch e.kind {
+	case leafKind:
+		return e.bitmap.Contains(x)
+	case andKind:
+		for _, c := range e.children {
+			if !contains(c, x) {
+				return false
+			}
+		}
+		return true
+	case orKind:
+		for _, c := range e.children {
+			if contains(c, x) {
+				return true
+			}
+		}
+		return false
+	}
+	return !contains(e.children[0], x)
+}
+
+func TestEvaluateRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	for trial := 0; trial < 30; trial++ {
+		e := randomExpr(r, 3)
+
+		// the expression restricted to [0, testRange), by brute force
+		expected := roaring.NewBitmap()
+		for x := uint32(0); x < testRange; x++ {
+			if contains(e, x) {
+				expected.Add(x)
+			}
+		}
+		// beyond testRange, all values behave the same
+		outside := contains(e, testRange)
+
+		result := e.Evaluate()
+		inRange := result.Clone()
+		inRange.RemoveRange(testRange, roaring.MaxRange)
+		assert.True(t, expected.Equals(inRange))
+		assert.Equal(t, outside, result.Contains(testRange))
+		assert.Equal(t, outside, result.Contains(roaring.MaxUint32))
+
+		card := expected.GetCardinality()
+		if outside {
+			card += roaring.MaxRange - testRange
+		}
+		assert.Equal(t, card, result.GetCardinality())
+		assert.Equal(t, card, e.Cardinality())
+		assert.Equal(t, card == 0, e.IsEmpty())
+
+		if !outside {
+			assert.Equal(t, expected.ToArray(), iteratorValues(e.Iterator()))
+		}
+	}
+}
+
+func iteratorValues(it roaring.IntIterable) []uint32 {
+	values := []uint32{}
+	for it.HasNext() {
+		values = append(values, it.Next())
+	}
+	return values
+}
+
+func TestIteratorAdvanceIfNeeded(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+	for trial := 0; trial < 30; trial++ {
+		e := randomExpr(r, 3)
+		expected := e.Evaluate()
+
+		it := e.Iterator()
+		minval := uint32(0)
+		for step := 0; step < 20; step++ {
+			minval += uint32(r.Intn(testRange / 8))
+			it.AdvanceIfNeeded(minval)
+			next := expected.NextValue(minval)
+			if !assert.Equal(t, next >= 0, it.HasNext()) || next < 0 {
+				break
+			}
+			assert.EqualValues(t, next, it.PeekNext())
+			assert.EqualValues(t, next, it.Next())
+			minval = uint32(next) + 1
+		}
+
+		// jumping beyond the test range, where all values behave the same
+		it = e.Iterator()
+		it.AdvanceIfNeeded(roaring.MaxUint32)
+		assert.Equal(t, expected.Contains(roaring.MaxUint32), it.HasNext())
+	}
+}
+
+func TestNextKeySkipsKeys(t *testing.T) {
+	a := roaring.BitmapOf(1, 5<<16, 9<<16)
+	b := roaring.BitmapOf(2, 3<<16, 9<<16+1)
+	c := roaring.BitmapOf(7 << 16)
+
+	and := And(Bitmap(a), Bitmap(b), Not(Bitmap(c))).plan()
+	assert.Equal(t, 0, and.nextKey(0))
+	assert.Equal(t, 9, and.nextKey(1))
+	assert.Equal(t, keyCount, and.nextKey(10))
+
+	or := Or(Bitmap(c), Bitmap(b)).plan()
+	assert.Equal(t, 0, or.nextKey(0))
+	assert.Equal(t, 3, or.nextKey(1))
+	assert.Equal(t, 7, or.nextKey(4))
+	assert.Equal(t, keyCount, or.nextKey(10))
+	assert.Equal(t, keyCount, Or().plan().nextKey(0))
+
+	not := Not(Bitmap(a)).plan()
+	assert.Equal(t, 4, not.nextKey(4))
+	assert.Equal(t, keyCount, not.nextKey(keyCount))
+}
+
+func TestEvaluateDoesNotModifyOperands(t *testing.T) {
+	a := roaring.BitmapOf(1, 2, 3, 4, 5, 1<<20)
+	b := roaring.BitmapOf(2, 3, 4, 1<<20)
+	c := roaring.BitmapOf(4, 5, 6)
+	d := roaring.BitmapOf(3)
+
+	e := And(Bitmap(a), Or(Bitmap(b), Bitmap(c)), Not(Bitmap(d)))
+	result := e.Evaluate()
+	assert.Equal(t, []uint32{2, 4, 5, 1 << 20}, result.ToArray())
+
+	result.Add(100)
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5, 1 << 20}, a.ToArray())
+	assert.Equal(t, []uint32{2, 3, 4, 1 << 20}, b.ToArray())
+
+	// single leaves are copied too
+	single := Bitmap(a).Evaluate()
+	single.Add(100)
+	assert.False(t, a.Contains(100))
+}
+
+func TestEvaluateEdgeCases(t *testing.T) {
+	a := roaring.BitmapOf(1, 2, 3)
+
+	assert.True(t, Or().Evaluate().IsEmpty())
+	assert.EqualValues(t, roaring.MaxRange, And().Cardinality())
+	assert.EqualValues(t, roaring.MaxRange, And().Evaluate().GetCardinality())
+	assert.EqualValues(t, roaring.MaxRange-3, Not(Bitmap(a)).Cardinality())
+	assert.Equal(t, []uint32{1, 2, 3}, And(Not(Not(Bitmap(a)))).Evaluate().ToArray())
+	assert.True(t, And(Bitmap(a), Or()).Evaluate().IsEmpty())
+	assert.Equal(t, []uint32{2}, AndNot(Bitmap(a), Bitmaps(roaring.BitmapOf(1), roaring.BitmapOf(3))...).Evaluate().ToArray())
+}
+
+func TestAndOrdersOperandsByCardinality(t *testing.T) {
+	small := roaring.BitmapOf(1, 2)
+	large := roaring.NewBitmap()
+	large.AddRange(0, 100000)
+	union := Or(Bitmap(large), Bitmap(small))
+
+	positives, negatives := And(union, Not(Bitmap(small)), Bitmap(large), Bitmap(small)).split()
+	assert.Len(t, negatives, 1)
+	assert.Equal(t, small, positives[0].bitmap)
+	assert.Equal(t, large, positives[1].bitmap)
+	assert.Equal(t, union, positives[2])
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	r := rand.New(rand.NewSource(12))
+	bitmaps := make([]*roaring.Bitmap, 6)
+	for i := range bitmaps {
+		bitmaps[i] = roaring.NewBitmap()
+		for j := 0; j < 200000; j++ {
+			bitmaps[i].Add(uint32(r.Intn(1 << 24)))
+		}
+	}
+	e := And(Bitmap(bitmaps[0]), Or(Bitmaps(bitmaps[1:4]...)...), Not(Bitmap(bitmaps[4])), Bitmap(bitmaps[5]))
+	b.Run("Evaluate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.Evaluate()
+		}
+	})
+	b.Run("Cardinality", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			e.Cardinality()
+		}
+	})
+}
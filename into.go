@@ -0,0 +1,493 @@
+package roaring
+
+// This file contains the destination-based variants of the binary operations
+// (AndInto, OrInto, XorInto, AndNotInto). They compute the same result as And,
+// Or, Xor and AndNot but store it in a caller-supplied bitmap whose containers
+// are recycled, so that repeated evaluations generate little garbage.
+
+// containerPool holds the containers of a destination bitmap so that they
+// can be recycled while computing the new content of the bitmap.
+type containerPool struct {
+	arrays  []*arrayContainer
+	bitmaps []*bitmapContainer
+}
+
+// recycle moves the containers of rb into the pool and empties rb, keeping
+// the capacity of its key and container slices. Containers that are shared
+// with other bitmaps (copy-on-write) are left alone.
+func (p *containerPool) recycle(rb *Bitmap) {
+	ra := &rb.highlowcontainer
+	arrays, bitmaps := 0, 0
+	for i, c := range ra.containers {
+		if ra.needsCopyOnWrite(i) {
+			continue
+		}
+		switch c.(type) {
+		case *arrayContainer:
+			arrays++
+		case *bitmapContainer:
+			bitmaps++
+		}
+	}
+	p.arrays = make([]*arrayContainer, 0, arrays)
+	p.bitmaps = make([]*bitmapContainer, 0, bitmaps)
+	for i, c := range ra.containers {
+		if !ra.needsCopyOnWrite(i) {
+			p.put(c)
+		}
+	}
+	ra.resize(0)
+	ra.conserz = nil
+}
+
+func (p *containerPool) put(c container) {
+	switch x := c.(type) {
+	case *arrayContainer:
+		p.arrays = append(p.arrays, x)
+	case *bitmapContainer:
+		p.bitmaps = append(p.bitmaps, x)
+	}
+}
+
+// array returns an array container with the given length, its content is undefined.
+func (p *containerPool) array(size int) *arrayContainer {
+	n := len(p.arrays)
+	if n == 0 {
+		return newArrayContainerSize(size)
+	}
+	ac := p.arrays[n-1]
+	p.arrays[n-1] = nil
+	p.arrays = p.arrays[:n-1]
+	ac.realloc(size)
+	return ac
+}
+
+// bitmap returns a bitmap container, its content is undefined.
+func (p *containerPool) bitmap() *bitmapContainer {
+	n := len(p.bitmaps)
+	if n == 0 {
+		return newBitmapContainer()
+	}
+	bc := p.bitmaps[n-1]
+	p.bitmaps[n-1] = nil
+	p.bitmaps = p.bitmaps[:n-1]
+	return bc
+}
+
+// copyOf returns a copy of c, using a pooled container when possible.
+func (p *containerPool) copyOf(c container) container {
+	switch x := c.(type) {
+	case *arrayContainer:
+		ac := p.array(len(x.content))
+		copy(ac.content, x.content)
+		return ac
+	case *bitmapContainer:
+		bc := p.bitmap()
+		bc.resetTo(x)
+		return bc
+	}
+	return c.clone()
+}
+
+// appendCopy appends a copy of the container sa[i] to ra, following the
+// copy-on-write rules of roaringArray.appendCopy.
+func (p *containerPool) appendCopy(ra *roaringArray, sa roaringArray, i int) {
+	if (ra.copyOnWrite && sa.copyOnWrite) || sa.needsCopyOnWrite(i) {
+		ra.appendCopy(sa, i)
+		return
+	}
+	ra.appendContainer(sa.keys[i], p.copyOf(sa.containers[i]), false)
+}
+
+// appendResult appends c to ra if it is not empty, and recycles it otherwise.
+func (p *containerPool) appendResult(ra *roaringArray, key uint16, c container) {
+	if c.getCardinality() > 0 {
+		ra.appendContainer(key, c, false)
+	} else {
+		p.put(c)
+	}
+}
+
+const (
+	wordsAnd = iota
+	wordsXor
+	wordsAndNot
+)
+
+// combineBitmaps computes a word-wise operation between two bitmap
+// containers, producing an array container if the result is small.
+func (p *containerPool) combineBitmaps(b1, b2 *bitmapContainer, op int) container {
+	var card int
+	switch op {
+	case wordsAnd:
+		card = int(popcntAndSlice(b1.bitmap, b2.bitmap))
+	case wordsXor:
+		card = int(popcntXorSlice(b1.bitmap, b2.bitmap))
+	default:
+		card = int(popcntMaskSlice(b1.bitmap, b2.bitmap))
+	}
+	if card <= arrayDefaultMaxSize {
+		ac := p.array(card)
+		switch op {
+		case wordsAnd:
+			fillArrayAND(ac.content, b1.bitmap, b2.bitmap)
+		case wordsXor:
+			fillArrayXOR(ac.content, b1.bitmap, b2.bitmap)
+		default:
+			fillArrayANDNOT(ac.content, b1.bitmap, b2.bitmap)
+		}
+		return ac
+	}
+	bc := p.bitmap()
+	switch op {
+	case wordsAnd:
+		for k := range bc.bitmap {
+			bc.bitmap[k] = b1.bitmap[k] & b2.bitmap[k]
+		}
+	case wordsXor:
+		for k := range bc.bitmap {
+			bc.bitmap[k] = b1.bitmap[k] ^ b2.bitmap[k]
+		}
+	default:
+		for k := range bc.bitmap {
+			bc.bitmap[k] = b1.bitmap[k] &^ b2.bitmap[k]
+		}
+	}
+	bc.cardinality = card
+	return bc
+}
+
+// shrink converts bc to an array container if its cardinality is small
+// enough, recycling bc.
+func (p *containerPool) shrink(bc *bitmapContainer) container {
+	if bc.cardinality > arrayDefaultMaxSize {
+		return bc
+	}
+	ac := p.array(bc.cardinality)
+	bc.fillArray(ac.content)
+	p.put(bc)
+	return ac
+}
+
+func (p *containerPool) and(c1, c2 container) container {
+	switch x1 := c1.(type) {
+	case *arrayContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			ac := p.array(minOfInt(len(x1.content), len(x2.content)))
+			ac.content = ac.content[:intersection2by2(x1.content, x2.content, ac.content)]
+			return ac
+		case *bitmapContainer:
+			return p.andArrayBitmap(x1, x2)
+		}
+	case *bitmapContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			return p.andArrayBitmap(x2, x1)
+		case *bitmapContainer:
+			return p.combineBitmaps(x1, x2, wordsAnd)
+		}
+	}
+	return c1.and(c2)
+}
+
+func (p *containerPool) andArrayBitmap(ac *arrayContainer, bc *bitmapContainer) container {
+	answer := p.array(len(ac.content))
+	pos := 0
+	for _, v := range ac.content {
+		answer.content[pos] = v
+		pos += int(bc.bitValue(v))
+	}
+	answer.content = answer.content[:pos]
+	return answer
+}
+
+func (p *containerPool) or(c1, c2 container) container {
+	switch x1 := c1.(type) {
+	case *arrayContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			if len(x1.content)+len(x2.content) <= arrayDefaultMaxSize {
+				ac := p.array(len(x1.content) + len(x2.content))
+				ac.content = ac.content[:union2by2(x1.content, x2.content, ac.content)]
+				return ac
+			}
+			return p.orBitmap(x1, x2)
+		case *bitmapContainer:
+			return p.orBitmap(x2, x1)
+		}
+	case *bitmapContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer, *bitmapContainer:
+			return p.orBitmap(x1, x2)
+		}
+	}
+	return c1.or(c2)
+}
+
+func (p *containerPool) orBitmap(c1, c2 container) container {
+	bc := p.bitmap()
+	bc.resetTo(c1)
+	result := bc.ior(c2)
+	if result != container(bc) {
+		// the union is full
+		p.put(bc)
+	}
+	return result
+}
+
+func (p *containerPool) xor(c1, c2 container) container {
+	switch x1 := c1.(type) {
+	case *arrayContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			if len(x1.content)+len(x2.content) <= arrayDefaultMaxSize {
+				ac := p.array(len(x1.content) + len(x2.content))
+				ac.content = ac.content[:exclusiveUnion2by2(x1.content, x2.content, ac.content)]
+				return ac
+			}
+			bc := p.bitmap()
+			bc.resetTo(x1)
+			return p.flip(bc, x2)
+		case *bitmapContainer:
+			bc := p.bitmap()
+			bc.resetTo(x2)
+			return p.flip(bc, x1)
+		}
+	case *bitmapContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			bc := p.bitmap()
+			bc.resetTo(x1)
+			return p.flip(bc, x2)
+		case *bitmapContainer:
+			return p.combineBitmaps(x1, x2, wordsXor)
+		}
+	}
+	return c1.xor(c2)
+}
+
+// flip toggles the values of ac in bc, which belongs to the pool user.
+func (p *containerPool) flip(bc *bitmapContainer, ac *arrayContainer) container {
+	for _, v := range ac.content {
+		i := uint(v) >> 6
+		mask := uint64(1) << (v % 64)
+		bc.cardinality += 1 - 2*int((bc.bitmap[i]&mask)>>(v%64))
+		bc.bitmap[i] ^= mask
+	}
+	return p.shrink(bc)
+}
+
+func (p *containerPool) andNot(c1, c2 container) container {
+	switch x1 := c1.(type) {
+	case *arrayContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			ac := p.array(len(x1.content))
+			ac.content = ac.content[:difference(x1.content, x2.content, ac.content)]
+			return ac
+		case *bitmapContainer:
+			ac := p.array(len(x1.content))
+			pos := 0
+			for _, v := range x1.content {
+				ac.content[pos] = v
+				pos += 1 - int(x2.bitValue(v))
+			}
+			ac.content = ac.content[:pos]
+			return ac
+		}
+	case *bitmapContainer:
+		switch x2 := c2.(type) {
+		case *arrayContainer:
+			bc := p.bitmap()
+			bc.resetTo(x1)
+			for _, v := range x2.content {
+				i := uint(v) >> 6
+				mask := uint64(1) << (v % 64)
+				bc.cardinality -= int((bc.bitmap[i] & mask) >> (v % 64))
+				bc.bitmap[i] &^= mask
+			}
+			return p.shrink(bc)
+		case *bitmapContainer:
+			return p.combineBitmaps(x1, x2, wordsAndNot)
+		}
+	}
+	return c1.andNot(c2)
+}
+
+// AndInto computes the intersection between x1 and x2 and stores it in dst,
+// replacing its previous content. The containers of dst are reused where
+// possible, which makes AndInto cheaper than And when called repeatedly
+// with the same destination. dst may be one of the operands.
+func AndInto(dst, x1, x2 *Bitmap) {
+	if dst == x1 {
+		dst.And(x2)
+		return
+	}
+	if dst == x2 {
+		dst.And(x1)
+		return
+	}
+	var pool containerPool
+	pool.recycle(dst)
+	answer := &dst.highlowcontainer
+
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 == s2 {
+			c := pool.and(x1.highlowcontainer.getContainerAtIndex(pos1), x2.highlowcontainer.getContainerAtIndex(pos2))
+			pool.appendResult(answer, s1, c)
+			pos1++
+			pos2++
+		} else if s1 < s2 {
+			pos1 = x1.highlowcontainer.advanceUntil(s2, pos1)
+		} else {
+			pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+		}
+	}
+}
+
+// OrInto computes the union between x1 and x2 and stores it in dst,
+// replacing its previous content. The containers of dst are reused where
+// possible, which makes OrInto cheaper than Or when called repeatedly
+// with the same destination. dst may be one of the operands.
+func OrInto(dst, x1, x2 *Bitmap) {
+	if dst == x1 {
+		dst.Or(x2)
+		return
+	}
+	if dst == x2 {
+		dst.Or(x1)
+		return
+	}
+	var pool containerPool
+	pool.recycle(dst)
+	answer := &dst.highlowcontainer
+
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 < s2 {
+			pool.appendCopy(answer, x1.highlowcontainer, pos1)
+			pos1++
+		} else if s1 > s2 {
+			pool.appendCopy(answer, x2.highlowcontainer, pos2)
+			pos2++
+		} else {
+			c := pool.or(x1.highlowcontainer.getContainerAtIndex(pos1), x2.highlowcontainer.getContainerAtIndex(pos2))
+			answer.appendContainer(s1, c, false)
+			pos1++
+			pos2++
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		pool.appendCopy(answer, x1.highlowcontainer, pos1)
+	}
+	for ; pos2 < length2; pos2++ {
+		pool.appendCopy(answer, x2.highlowcontainer, pos2)
+	}
+}
+
+// XorInto computes the symmetric difference between x1 and x2 and stores it
+// in dst, replacing its previous content. The containers of dst are reused
+// where possible, which makes XorInto cheaper than Xor when called repeatedly
+// with the same destination. dst may be one of the operands.
+func XorInto(dst, x1, x2 *Bitmap) {
+	if x1 == x2 {
+		dst.Clear()
+		return
+	}
+	if dst == x1 {
+		dst.Xor(x2)
+		return
+	}
+	if dst == x2 {
+		dst.Xor(x1)
+		return
+	}
+	var pool containerPool
+	pool.recycle(dst)
+	answer := &dst.highlowcontainer
+
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 < s2 {
+			pool.appendCopy(answer, x1.highlowcontainer, pos1)
+			pos1++
+		} else if s1 > s2 {
+			pool.appendCopy(answer, x2.highlowcontainer, pos2)
+			pos2++
+		} else {
+			c := pool.xor(x1.highlowcontainer.getContainerAtIndex(pos1), x2.highlowcontainer.getContainerAtIndex(pos2))
+			pool.appendResult(answer, s1, c)
+			pos1++
+			pos2++
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		pool.appendCopy(answer, x1.highlowcontainer, pos1)
+	}
+	for ; pos2 < length2; pos2++ {
+		pool.appendCopy(answer, x2.highlowcontainer, pos2)
+	}
+}
+
+// AndNotInto computes the difference between x1 and x2 and stores it in dst,
+// replacing its previous content. The containers of dst are reused where
+// possible, which makes AndNotInto cheaper than AndNot when called repeatedly
+// with the same destination. dst may be one of the operands.
+func AndNotInto(dst, x1, x2 *Bitmap) {
+	if x1 == x2 {
+		dst.Clear()
+		return
+	}
+	if dst == x1 {
+		dst.AndNot(x2)
+		return
+	}
+	if dst == x2 {
+		// the containers of dst are needed until the end
+		dst.highlowcontainer = AndNot(x1, x2).highlowcontainer
+		return
+	}
+	var pool containerPool
+	pool.recycle(dst)
+	answer := &dst.highlowcontainer
+
+	pos1 := 0
+	pos2 := 0
+	length1 := x1.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	for pos1 < length1 && pos2 < length2 {
+		s1 := x1.highlowcontainer.getKeyAtIndex(pos1)
+		s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+		if s1 < s2 {
+			pool.appendCopy(answer, x1.highlowcontainer, pos1)
+			pos1++
+		} else if s1 == s2 {
+			c := pool.andNot(x1.highlowcontainer.getContainerAtIndex(pos1), x2.highlowcontainer.getContainerAtIndex(pos2))
+			pool.appendResult(answer, s1, c)
+			pos1++
+			pos2++
+		} else {
+			pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		pool.appendCopy(answer, x1.highlowcontainer, pos1)
+	}
+}
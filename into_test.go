@@ -0,0 +1,166 @@
+package roaring
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomIntoTestBitmap(r *rand.Rand) *Bitmap {
+	rb := NewBitmap()
+	for key := uint32(0); key < 8; key++ {
+		switch r.Intn(4) {
+		case 0:
+			// absent
+		case 1:
+			for i := 0; i < 1+r.Intn(3000); i++ {
+				rb.Add(key<<16 | uint32(r.Intn(1<<16)))
+			}
+		case 2:
+			for i := 0; i < 3000+r.Intn(40000); i++ {
+				rb.Add(key<<16 | uint32(r.Intn(1<<16)))
+			}
+		case 3:
+			start := uint64(key<<16) + uint64(r.Intn(1<<15))
+			rb.AddRange(start, start+uint64(r.Intn(1<<15)))
+			rb.Add(key<<16 | uint32(r.Intn(1<<16)))
+		}
+	}
+	if r.Intn(2) == 0 {
+		rb.RunOptimize()
+	}
+	return rb
+}
+
+func TestBinaryOperationsInto(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	dst := NewBitmap()
+	for trial := 0; trial < 200; trial++ {
+		x1 := randomIntoTestBitmap(r)
+		x2 := randomIntoTestBitmap(r)
+		c1 := x1.Clone()
+		c2 := x2.Clone()
+
+		AndInto(dst, x1, x2)
+		assert.True(t, And(c1, c2).Equals(dst))
+		OrInto(dst, x1, x2)
+		assert.True(t, Or(c1, c2).Equals(dst))
+		XorInto(dst, x1, x2)
+		assert.True(t, Xor(c1, c2).Equals(dst))
+		AndNotInto(dst, x1, x2)
+		assert.True(t, AndNot(c1, c2).Equals(dst))
+		assert.Equal(t, AndNot(c1, c2).GetCardinality(), dst.GetCardinality())
+
+		// the operands are left untouched, even after dst is modified
+		dst.AddRange(0, 1<<19)
+		assert.True(t, c1.Equals(x1))
+		assert.True(t, c2.Equals(x2))
+	}
+}
+
+func TestBinaryOperationsIntoAliasing(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	for trial := 0; trial < 20; trial++ {
+		x1 := randomIntoTestBitmap(r)
+		x2 := randomIntoTestBitmap(r)
+		ops := []struct {
+			into func(dst, x1, x2 *Bitmap)
+			op   func(x1, x2 *Bitmap) *Bitmap
+		}{
+			{AndInto, And},
+			{OrInto, Or},
+			{XorInto, Xor},
+			{AndNotInto, AndNot},
+		}
+		for _, o := range ops {
+			dst := x1.Clone()
+			o.into(dst, dst, x2)
+			assert.True(t, o.op(x1, x2).Equals(dst))
+
+			dst = x2.Clone()
+			o.into(dst, x1, dst)
+			assert.True(t, o.op(x1, x2).Equals(dst))
+
+			dst = x1.Clone()
+			o.into(dst, dst, dst)
+			assert.True(t, o.op(x1, x1).Equals(dst))
+
+			dst = x2.Clone()
+			o.into(dst, x1, x1)
+			assert.True(t, o.op(x1, x1).Equals(dst))
+		}
+	}
+}
+
+func TestBinaryOperationsIntoCopyOnWrite(t *testing.T) {
+	x1 := BitmapOf(1, 2, 3, 1<<16)
+	x2 := BitmapOf(2, 3, 4, 2<<16)
+	x1.SetCopyOnWrite(true)
+	x2.SetCopyOnWrite(true)
+
+	dst := NewBitmap()
+	dst.SetCopyOnWrite(true)
+	OrInto(dst, x1, x2)
+	assert.True(t, BitmapOf(1, 2, 3, 4, 1<<16, 2<<16).Equals(dst))
+
+	// the containers shared with x1 and x2 must not be recycled
+	AndInto(dst, x1, x2)
+	assert.True(t, BitmapOf(2, 3).Equals(dst))
+	assert.True(t, BitmapOf(1, 2, 3, 1<<16).Equals(x1))
+	assert.True(t, BitmapOf(2, 3, 4, 2<<16).Equals(x2))
+
+	view := NewBitmap()
+	buf, err := x1.ToBytes()
+	assert.NoError(t, err)
+	_, err = view.FromBuffer(buf)
+	assert.NoError(t, err)
+	XorInto(view, x2, BitmapOf(7))
+	assert.True(t, BitmapOf(1, 2, 3, 1<<16).Equals(x1))
+	copied := NewBitmap()
+	_, err = copied.FromBuffer(buf)
+	assert.NoError(t, err)
+	assert.True(t, x1.Equals(copied))
+}
+
+func TestBinaryOperationsIntoReuseContainers(t *testing.T) {
+	x1 := NewBitmap()
+	x2 := NewBitmap()
+	for i := uint32(0); i < 1<<20; i += 3 {
+		x1.Add(i)
+	}
+	for i := uint32(0); i < 1<<20; i += 5 {
+		x2.Add(i)
+	}
+	x2.AddMany([]uint32{1 << 21, 1<<21 + 7})
+	x1.AddMany([]uint32{1<<21 + 7, 1<<21 + 9})
+
+	dst := NewBitmap()
+	ops := []func(dst, x1, x2 *Bitmap){AndInto, OrInto, XorInto, AndNotInto}
+	for _, op := range ops {
+		op(dst, x1, x2)
+		allocs := testing.AllocsPerRun(10, func() {
+			op(dst, x1, x2)
+		})
+		assert.True(t, allocs <= 3, "%v allocations", allocs)
+	}
+}
+
+func BenchmarkAndInto(b *testing.B) {
+	r := rand.New(rand.NewSource(9))
+	x1 := randomIntoTestBitmap(r)
+	x2 := randomIntoTestBitmap(r)
+	b.Run("And", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			And(x1, x2)
+		}
+	})
+	b.Run("AndInto", func(b *testing.B) {
+		b.ReportAllocs()
+		dst := NewBitmap()
+		for i := 0; i < b.N; i++ {
+			AndInto(dst, x1, x2)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package roaring
 type manyIterable interface {
 	nextMany(hs uint32, buf []uint32) int
 	nextMany64(hs uint64, buf []uint64) int
+	advanceIfNeeded(minval uint16)
 }
 
 func (si *shortIterator) nextMany(hs uint32, buf []uint32) int {
@@ -10,6 +10,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -71,7 +72,7 @@ func (rb *Bitmap) ReadFrom(reader io.Reader) (p int64, err error) {
 	stream := byteInputAdapterPool.Get().(*byteInputAdapter)
 	stream.reset(reader)
 
-	p, err = rb.highlowcontainer.readFrom(stream)
+	p, err = rb.highlowcontainer.readFrom(stream, nil)
 	byteInputAdapterPool.Put(stream)
 
 	return
@@ -103,12 +104,80 @@ func (rb *Bitmap) FromBuffer(buf []byte) (p int64, err error) {
 	stream := byteBufferPool.Get().(*byteBuffer)
 	stream.reset(buf)
 
-	p, err = rb.highlowcontainer.readFrom(stream)
+	p, err = rb.highlowcontainer.readFrom(stream, nil)
 	byteBufferPool.Put(stream)
 
 	return
 }
 
+// ReadFromStrict reads a serialized version of this bitmap from stream like
+// ReadFrom, and then checks that the result is a valid bitmap (see Validate).
+// It is meant for input that cannot be trusted: corrupt input results in an
+// error instead of a bitmap that may later misbehave or panic. The bitmap is
+// left empty when an error is returned.
+func (rb *Bitmap) ReadFromStrict(reader io.Reader) (p int64, err error) {
+	return rb.ReadFromWithOptions(reader, DeserializationOptions{Validate: true})
+}
+
+// FromBufferStrict creates a bitmap from its serialized version stored in
+// buffer like FromBuffer, and then checks that the result is a valid bitmap
+// (see Validate). It is meant for input that cannot be trusted: corrupt input
+// results in an error instead of a bitmap that may later misbehave or panic.
+// The bitmap is left empty when an error is returned.
+func (rb *Bitmap) FromBufferStrict(buf []byte) (p int64, err error) {
+	return rb.FromBufferWithOptions(buf, DeserializationOptions{Validate: true})
+}
+
+// ReadFromWithOptions reads a serialized version of this bitmap from stream
+// like ReadFrom, within the limits given by opts. A *DeserializationLimitError
+// is returned when the input exceeds a limit. The bitmap is left empty when an
+// error is returned.
+func (rb *Bitmap) ReadFromWithOptions(reader io.Reader, opts DeserializationOptions) (p int64, err error) {
+	stream := byteInputAdapterPool.Get().(*byteInputAdapter)
+	stream.reset(reader)
+
+	p, err = rb.highlowcontainer.readFrom(stream, &opts)
+	byteInputAdapterPool.Put(stream)
+
+	if err == nil && opts.Validate {
+		err = rb.Validate()
+	}
+	if err != nil {
+		rb.Clear()
+	}
+	return
+}
+
+// FromBufferWithOptions creates a bitmap from its serialized version stored
+// in buffer like FromBuffer, within the limits given by opts. A
+// *DeserializationLimitError is returned when the input exceeds a limit. The
+// bitmap is left empty when an error is returned.
+func (rb *Bitmap) FromBufferWithOptions(buf []byte, opts DeserializationOptions) (p int64, err error) {
+	stream := byteBufferPool.Get().(*byteBuffer)
+	stream.reset(buf)
+
+	p, err = rb.highlowcontainer.readFrom(stream, &opts)
+	byteBufferPool.Put(stream)
+
+	if err == nil && opts.Validate {
+		err = rb.Validate()
+	}
+	if err != nil {
+		rb.Clear()
+	}
+	return
+}
+
+// Validate checks the internal invariants of the bitmap: keys are sorted
+// and unique, array containers are sorted and hold at most 4096 values,
+// bitmap containers have the cardinality given by their bits, and runs are
+// sorted and do not overlap. Empty containers are not allowed. Bitmaps
+// built with the API of this package are always valid, Validate is meant
+// for bitmaps deserialized from untrusted input.
+func (rb *Bitmap) Validate() error {
+	return rb.highlowcontainer.validate()
+}
+
 var (
 	byteBufferPool = sync.Pool{
 		New: func() interface{} {
@@ -349,6 +418,8 @@ type ManyIntIterable interface {
 	NextMany(buf []uint32) int
 	// NextMany64 fills up buf with 64 bit values, uses hs as a mask (OR), returns how many values were returned
 	NextMany64(hs uint64, buf []uint64) int
+	// AdvanceIfNeeded advances as long as the next value is smaller than minval
+	AdvanceIfNeeded(minval uint32)
 }
 
 type manyIntIterator struct {
@@ -403,6 +474,20 @@ func (ii *manyIntIterator) NextMany64(hs64 uint64, buf []uint64) int {
 	return n
 }
 
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (ii *manyIntIterator) AdvanceIfNeeded(minval uint32) {
+	to := minval >> 16
+
+	for ii.iter != nil && (ii.hs>>16) < to {
+		ii.pos++
+		ii.init()
+	}
+
+	if ii.iter != nil && (ii.hs>>16) == to {
+		ii.iter.advanceIfNeeded(lowbits(minval))
+	}
+}
+
 func newManyIntIterator(a *Bitmap) *manyIntIterator {
 	p := new(manyIntIterator)
 	p.pos = 0
@@ -411,6 +496,175 @@ func newManyIntIterator(a *Bitmap) *manyIntIterator {
 	return p
 }
 
+// Range represents the integers in [Start, End). End is a 64-bit value
+// so that ranges ending with MaxUint32 can be represented.
+type Range struct {
+	Start uint64
+	End   uint64
+}
+
+// RangeIterable allows you to iterate over the maximal ranges of consecutive
+// values in a Bitmap
+type RangeIterable interface {
+	HasNext() bool
+	Next() Range
+}
+
+type rangeIterator struct {
+	pos              int
+	hs               uint64
+	ivs              []interval16
+	ivPos            int
+	buf              []interval16
+	highlowcontainer *roaringArray
+}
+
+// HasNext returns true if there are more ranges to iterate over
+func (ri *rangeIterator) HasNext() bool {
+	return ri.ivPos < len(ri.ivs)
+}
+
+func (ri *rangeIterator) init() {
+	ri.ivs, ri.ivPos = nil, 0
+	if ri.highlowcontainer.size() > ri.pos {
+		if rc, ok := ri.highlowcontainer.getContainerAtIndex(ri.pos).(*runContainer16); ok {
+			ri.ivs = rc.iv
+		} else {
+			ri.buf = appendIntervals(ri.buf[:0], ri.highlowcontainer.getContainerAtIndex(ri.pos))
+			ri.ivs = ri.buf
+		}
+		ri.hs = uint64(ri.highlowcontainer.getKeyAtIndex(ri.pos)) << 16
+	}
+}
+
+// Next returns the next range; ranges touching the end of a container
+// are merged with ranges starting at the beginning of the next container.
+func (ri *rangeIterator) Next() Range {
+	iv := ri.ivs[ri.ivPos]
+	r := Range{Start: ri.hs | uint64(iv.start), End: ri.hs | uint64(iv.last()) + 1}
+	ri.ivPos++
+	for ri.ivPos == len(ri.ivs) {
+		ri.pos++
+		ri.init()
+		if !ri.HasNext() || r.End != ri.hs|uint64(ri.ivs[0].start) {
+			break
+		}
+		r.End = ri.hs | uint64(ri.ivs[0].last()) + 1
+		ri.ivPos++
+	}
+	return r
+}
+
+func newRangeIterator(a *Bitmap) *rangeIterator {
+	p := new(rangeIterator)
+	p.highlowcontainer = &a.highlowcontainer
+	p.init()
+	return p
+}
+
+// appendIntervals appends the runs of consecutive values in c to buf
+func appendIntervals(buf []interval16, c container) []interval16 {
+	switch x := c.(type) {
+	case *runContainer16:
+		buf = append(buf, x.iv...)
+	case *arrayContainer:
+		for i := 0; i < len(x.content); {
+			j := i
+			for j+1 < len(x.content) && x.content[j+1] == x.content[j]+1 {
+				j++
+			}
+			buf = append(buf, interval16{start: x.content[i], length: x.content[j] - x.content[i]})
+			i = j + 1
+		}
+	case *bitmapContainer:
+		for start := x.NextSetBit(0); start >= 0; {
+			end := x.nextAbsentValue(uint16(start))
+			if end < 0 {
+				buf = append(buf, interval16{start: uint16(start), length: uint16(MaxUint16 - start)})
+				break
+			}
+			buf = append(buf, interval16{start: uint16(start), length: uint16(end - 1 - start)})
+			start = x.NextSetBit(end)
+		}
+	}
+	return buf
+}
+
+// RangeIterator creates a new RangeIterable to iterate over the maximal ranges
+// of consecutive integers contained in the bitmap, in sorted order; the iterator
+// becomes invalid if the bitmap is modified (e.g., with Add or Remove).
+func (rb *Bitmap) RangeIterator() RangeIterable {
+	return newRangeIterator(rb)
+}
+
+// ToRanges returns the maximal ranges of consecutive integers contained
+// in the bitmap, in sorted order.
+func (rb *Bitmap) ToRanges() []Range {
+	var ranges []Range
+	for it := rb.RangeIterator(); it.HasNext(); {
+		ranges = append(ranges, it.Next())
+	}
+	return ranges
+}
+
+// AddRanges adds the integers of all the given ranges to the bitmap.
+// The ranges may overlap and need not be sorted. Rather than adding
+// the ranges one at a time, a single container is built for each key.
+func (rb *Bitmap) AddRanges(ranges []Range) {
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Start >= r.End {
+			continue
+		}
+		if r.End-1 > MaxUint32 {
+			panic("rangeEnd-1 > MaxUint32")
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var ivs []interval16
+	currentKey := -1
+	flush := func() {
+		if len(ivs) == 0 {
+			return
+		}
+		rc := newRunContainer16TakeOwnership(ivs)
+		ivs = nil
+		i := rb.highlowcontainer.getIndex(uint16(currentKey))
+		if i >= 0 {
+			c := rb.highlowcontainer.getWritableContainerAtIndex(i).ior(rc)
+			rb.highlowcontainer.setContainerAtIndex(i, c)
+		} else {
+			rb.highlowcontainer.insertNewKeyValueAt(-i-1, uint16(currentKey), rc.toEfficientContainer())
+		}
+	}
+	for _, r := range sorted {
+		for start := r.Start; start < r.End; {
+			key := int(start >> 16)
+			last := uint64(key)<<16 | maxLowBit
+			if r.End-1 < last {
+				last = r.End - 1
+			}
+			if key != currentKey {
+				flush()
+				currentKey = key
+			}
+			n := len(ivs)
+			if n > 0 && int(ivs[n-1].last())+1 >= int(start&maxLowBit) {
+				// overlapping or adjacent: extend the previous run
+				if newLast := uint16(last); newLast > ivs[n-1].last() {
+					ivs[n-1].length = newLast - ivs[n-1].start
+				}
+			} else {
+				ivs = append(ivs, interval16{start: uint16(start), length: uint16(last - start)})
+			}
+			start = last + 1
+		}
+	}
+	flush()
+}
+
 // String creates a string representation of the Bitmap
 func (rb *Bitmap) String() string {
 	// inspired by https://github.com/fzandona/goroar/
@@ -505,6 +759,97 @@ func (rb *Bitmap) Maximum() uint32 {
 	return uint32(rb.highlowcontainer.containers[lastindex].maximum()) | (uint32(rb.highlowcontainer.keys[lastindex]) << 16)
 }
 
+// NextValue returns the smallest value in the bitmap that is greater than
+// or equal to x, or -1 if there is no such value.
+func (rb *Bitmap) NextValue(x uint32) int64 {
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(highbits(x))
+	if i >= 0 {
+		if v := ra.containers[i].nextValue(lowbits(x)); v >= 0 {
+			return int64(ra.keys[i])<<16 | int64(v)
+		}
+		i++
+	} else {
+		i = -i - 1
+	}
+	if i < len(ra.keys) {
+		return int64(ra.keys[i])<<16 | int64(ra.containers[i].minimum())
+	}
+	return -1
+}
+
+// PreviousValue returns the largest value in the bitmap that is smaller than
+// or equal to x, or -1 if there is no such value.
+func (rb *Bitmap) PreviousValue(x uint32) int64 {
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(highbits(x))
+	if i >= 0 {
+		if v := ra.containers[i].previousValue(lowbits(x)); v >= 0 {
+			return int64(ra.keys[i])<<16 | int64(v)
+		}
+	} else {
+		i = -i - 1
+	}
+	if i > 0 {
+		return int64(ra.keys[i-1])<<16 | int64(ra.containers[i-1].maximum())
+	}
+	return -1
+}
+
+// NextAbsentValue returns the smallest value that is greater than or equal
+// to x and that is not in the bitmap, or -1 if there is no such value.
+func (rb *Bitmap) NextAbsentValue(x uint32) int64 {
+	ra := &rb.highlowcontainer
+	hb := highbits(x)
+	i := ra.getIndex(hb)
+	if i < 0 {
+		return int64(x)
+	}
+	low := lowbits(x)
+	for {
+		if v := ra.containers[i].nextAbsentValue(low); v >= 0 {
+			return int64(ra.keys[i])<<16 | int64(v)
+		}
+		// the container is full from low onwards, look at the next key
+		if hb == MaxUint16 {
+			return -1
+		}
+		hb++
+		i++
+		if i == len(ra.keys) || ra.keys[i] != hb {
+			return int64(hb) << 16
+		}
+		low = 0
+	}
+}
+
+// PreviousAbsentValue returns the largest value that is smaller than or equal
+// to x and that is not in the bitmap, or -1 if there is no such value.
+func (rb *Bitmap) PreviousAbsentValue(x uint32) int64 {
+	ra := &rb.highlowcontainer
+	hb := highbits(x)
+	i := ra.getIndex(hb)
+	if i < 0 {
+		return int64(x)
+	}
+	low := lowbits(x)
+	for {
+		if v := ra.containers[i].previousAbsentValue(low); v >= 0 {
+			return int64(ra.keys[i])<<16 | int64(v)
+		}
+		// the container is full up to low, look at the previous key
+		if hb == 0 {
+			return -1
+		}
+		hb--
+		i--
+		if i < 0 || ra.keys[i] != hb {
+			return int64(hb)<<16 | MaxUint16
+		}
+		low = MaxUint16
+	}
+}
+
 // Contains returns true if the integer is contained in the bitmap
 func (rb *Bitmap) Contains(x uint32) bool {
 	hb := highbits(x)
@@ -544,7 +889,9 @@ func AddOffset64(x *Bitmap, offset int64) (answer *Bitmap) {
 		containerOffset64 = offset >> 16
 	}
 
-	if containerOffset64 >= (1<<16) || containerOffset64 <= -(1<<16) {
+	// with a container offset of -(1<<16), the values of the last container
+	// may still be shifted to the first one
+	if containerOffset64 >= (1<<16) || containerOffset64 < -(1<<16) {
 		return New()
 	}
 
@@ -552,13 +899,13 @@ func AddOffset64(x *Bitmap, offset int64) (answer *Bitmap) {
 	inOffset := (uint16)(offset - containerOffset64*(1<<16))
 
 	if inOffset == 0 {
-		answer = x.Clone()
-		for pos := 0; pos < answer.highlowcontainer.size(); pos++ {
-			key := int32(answer.highlowcontainer.getKeyAtIndex(pos))
+		answer = New()
+		for pos := 0; pos < x.highlowcontainer.size(); pos++ {
+			key := int32(x.highlowcontainer.getKeyAtIndex(pos))
 			key += containerOffset
 
 			if key >= 0 && key <= MaxUint16 {
-				answer.highlowcontainer.keys[pos] = uint16(key)
+				answer.highlowcontainer.appendContainer(uint16(key), x.highlowcontainer.getContainerAtIndex(pos).clone(), false)
 			}
 		}
 	} else {
@@ -740,6 +1087,99 @@ func (rb *Bitmap) Select(x uint32) (uint32, error) {
 	return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, rb.GetCardinality())
 }
 
+// RangeCardinality returns the number of integers in [rangeStart, rangeEnd).
+// Containers entirely inside the range contribute their stored cardinality.
+func (rb *Bitmap) RangeCardinality(rangeStart, rangeEnd uint64) uint64 {
+	if rangeEnd > MaxRange {
+		rangeEnd = MaxRange
+	}
+	if rangeStart >= rangeEnd {
+		return 0
+	}
+	hbStart := highbits(uint32(rangeStart))
+	hbLast := highbits(uint32(rangeEnd - 1))
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	answer := uint64(0)
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		answer += uint64(ra.containers[i].rangeCardinality(containerStart, containerEnd))
+	}
+	return answer
+}
+
+// ContainsRange returns true if all the integers in [rangeStart, rangeEnd)
+// are in the bitmap. An empty range is always contained.
+func (rb *Bitmap) ContainsRange(rangeStart, rangeEnd uint64) bool {
+	if rangeStart >= rangeEnd {
+		return true
+	}
+	if rangeEnd > MaxRange {
+		return false
+	}
+	hbStart := highbits(uint32(rangeStart))
+	hbLast := highbits(uint32(rangeEnd - 1))
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 || len(ra.keys)-i < int(hbLast-hbStart)+1 || ra.keys[i+int(hbLast-hbStart)] != hbLast {
+		// keys are sorted and unique: all the keys in between must be present
+		return false
+	}
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		v := ra.containers[i].nextAbsentValue(uint16(containerStart))
+		if v >= 0 && v < containerEnd {
+			return false
+		}
+	}
+	return true
+}
+
+// IntersectsRange returns true if at least one integer in [rangeStart, rangeEnd)
+// is in the bitmap.
+func (rb *Bitmap) IntersectsRange(rangeStart, rangeEnd uint64) bool {
+	if rangeEnd > MaxRange {
+		rangeEnd = MaxRange
+	}
+	if rangeStart >= rangeEnd {
+		return false
+	}
+	hbStart := highbits(uint32(rangeStart))
+	hbLast := highbits(uint32(rangeEnd - 1))
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		v := ra.containers[i].nextValue(uint16(containerStart))
+		if v >= 0 && v < containerEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// containerRange returns the part [start, end) of [rangeStart, rangeEnd)
+// that falls within the container with the given key.
+func containerRange(key, hbStart, hbLast uint16, rangeStart, rangeEnd uint64) (int, int) {
+	start, end := 0, maxCapacity
+	if key == hbStart {
+		start = int(lowbits(uint32(rangeStart)))
+	}
+	if key == hbLast {
+		end = int(lowbits(uint32(rangeEnd-1))) + 1
+	}
+	return start, end
+}
+
 // And computes the intersection between two bitmaps and stores the result in the current bitmap
 func (rb *Bitmap) And(x2 *Bitmap) {
 	pos1 := 0
@@ -889,6 +1329,136 @@ main:
 	return answer
 }
 
+// AndNotCardinality returns the cardinality of the difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) AndNotCardinality(x2 *Bitmap) uint64 {
+	pos1 := 0
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	answer := uint64(0)
+main:
+	for {
+		if (pos1 < length1) && (pos2 < length2) {
+			s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+			s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+			for {
+				if s1 < s2 {
+					answer += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+					pos1++
+					if pos1 == length1 {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+				} else if s1 > s2 {
+					pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+					if pos2 == length2 {
+						break main
+					}
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				} else {
+					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+					answer += uint64(c1.andNotCardinality(c2))
+					pos1++
+					pos2++
+					if (pos1 == length1) || (pos2 == length2) {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				}
+			}
+		} else {
+			break
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+	}
+	return answer
+}
+
+// XorCardinality returns the cardinality of the symmetric difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) XorCardinality(x2 *Bitmap) uint64 {
+	pos1 := 0
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	answer := uint64(0)
+main:
+	for {
+		if (pos1 < length1) && (pos2 < length2) {
+			s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+			s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+			for {
+				if s1 < s2 {
+					answer += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+					pos1++
+					if pos1 == length1 {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+				} else if s1 > s2 {
+					answer += uint64(x2.highlowcontainer.getContainerAtIndex(pos2).getCardinality())
+					pos2++
+					if pos2 == length2 {
+						break main
+					}
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				} else {
+					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+					answer += uint64(c1.xorCardinality(c2))
+					pos1++
+					pos2++
+					if (pos1 == length1) || (pos2 == length2) {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				}
+			}
+		} else {
+			break
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer += uint64(rb.highlowcontainer.getContainerAtIndex(pos1).getCardinality())
+	}
+	for ; pos2 < length2; pos2++ {
+		answer += uint64(x2.highlowcontainer.getContainerAtIndex(pos2).getCardinality())
+	}
+	return answer
+}
+
+// JaccardIndex returns the Jaccard similarity coefficient of two bitmaps,
+// that is the size of their intersection divided by the size of their union.
+// By convention, the index of two empty bitmaps is 0.
+func (rb *Bitmap) JaccardIndex(x2 *Bitmap) float64 {
+	intersection := rb.AndCardinality(x2)
+	union := rb.GetCardinality() + x2.GetCardinality() - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// OverlapCoefficient returns the size of the intersection of two bitmaps
+// divided by the size of the smaller of the two.
+// By convention, the coefficient is 0 when either bitmap is empty.
+func (rb *Bitmap) OverlapCoefficient(x2 *Bitmap) float64 {
+	smallest := rb.GetCardinality()
+	if c := x2.GetCardinality(); c < smallest {
+		smallest = c
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return float64(rb.AndCardinality(x2)) / float64(smallest)
+}
+
 // Intersects checks whether two bitmap intersects, bitmaps are not modified
 func (rb *Bitmap) Intersects(x2 *Bitmap) bool {
 	pos1 := 0
@@ -952,7 +1522,7 @@ func (rb *Bitmap) Xor(x2 *Bitmap) {
 					break
 				}
 			} else if s1 > s2 {
-				c := x2.highlowcontainer.getWritableContainerAtIndex(pos2)
+				c := x2.highlowcontainer.getContainerAtIndex(pos2).clone()
 				rb.highlowcontainer.insertNewKeyValueAt(pos1, x2.highlowcontainer.getKeyAtIndex(pos2), c)
 				length1++
 				pos1++
@@ -984,6 +1554,13 @@ func (rb *Bitmap) Or(x2 *Bitmap) {
 	pos2 := 0
 	length1 := rb.highlowcontainer.size()
 	length2 := x2.highlowcontainer.size()
+	if length1 > 0 && length2 > 0 &&
+		rb.highlowcontainer.getKeyAtIndex(length1-1) < x2.highlowcontainer.getKeyAtIndex(0) {
+		// all the keys of x2 come after those of rb, as when a bitmap is
+		// assembled key by key: there is nothing to merge
+		rb.highlowcontainer.appendCopyMany(x2.highlowcontainer, 0, length2)
+		return
+	}
 main:
 	for (pos1 < length1) && (pos2 < length2) {
 		s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
@@ -226,16 +226,15 @@ func assertAggregation(t *testing.T, expected uint64, aggr func(bitmaps ...*Bitm
 
 func TestParAggregations(t *testing.T) {
 	for _, p := range [...]int{0, 1, 2, 4} {
-		//andFunc := func(bitmaps ...*Bitmap) *Bitmap {
-		//	return ParAnd(p, bitmaps...)
-		//}
+		andFunc := func(bitmaps ...*Bitmap) *Bitmap {
+			return ParAnd(p, bitmaps...)
+		}
 		orFunc := func(bitmaps ...*Bitmap) *Bitmap {
 			return ParOr(p, bitmaps...)
 		}
 
 		t.Run(fmt.Sprintf("par%d", p), func(t *testing.T) {
-			//testAggregations(t, andFunc, orFunc, nil)
-			testAggregations(t, nil, orFunc, nil)
+			testAggregations(t, andFunc, orFunc, nil)
 		})
 	}
 }
@@ -249,7 +248,6 @@ func TestParAggregations2(t *testing.T) {
 	})
 }
 
-/*
 func TestParHeapAggregations(t *testing.T) {
 	orFunc := func(bitmaps ...*Bitmap) *Bitmap {
 		return ParHeapOr(0, bitmaps...)
@@ -257,12 +255,26 @@ func TestParHeapAggregations(t *testing.T) {
 
 	testAggregations(t, nil, orFunc, nil)
 }
-*/
 
 func TestFastAggregations(t *testing.T) {
-	testAggregations(t, nil, FastOr, nil)
+	testAggregations(t, FastAnd, FastOr, nil)
+}
+
+func TestHeapAggregations(t *testing.T) {
+	testAggregations(t, nil, HeapOr, HeapXor)
 }
 
-//func TestHeapAggregations(t *testing.T) {
-//	testAggregations(t, nil, HeapOr, HeapXor)
-//}
+func TestParAggregationsDoNotShareContainers(t *testing.T) {
+	rb1 := BitmapOf(1, 2, 3<<32)
+	rb2 := BitmapOf(2, 5<<32)
+
+	and := ParAnd(0, rb1, rb2)
+	and.Add(7)
+	or := ParHeapOr(0, rb1, rb2)
+	or.Add(3<<32 + 1)
+	or.Add(5<<32 + 1)
+
+	assert.Equal(t, []uint64{1, 2, 3 << 32}, rb1.ToArray())
+	assert.Equal(t, []uint64{2, 5 << 32}, rb2.ToArray())
+	assert.Equal(t, []uint64{2, 7}, and.ToArray())
+}
@@ -0,0 +1,120 @@
+package roaring64
+
+import "github.com/RoaringBitmap/roaring"
+
+// BitmapWriter builds a Bitmap from values that are added in ascending
+// order. Values sharing the current 32-bit key are handed to a
+// roaring.BitmapWriter, and the resulting 32-bit bitmap is stored when
+// the key changes.
+//
+// Values may also be added out of order, at a performance cost: values
+// with smaller keys than the current one are added to the bitmap directly.
+type BitmapWriter struct {
+	answer *Bitmap
+
+	// key is the current 32-bit key, or -1 if the buffer is empty
+	key    int64
+	writer *roaring.BitmapWriter
+}
+
+// NewBitmapWriter creates a new BitmapWriter writing to a new, empty bitmap.
+func NewBitmapWriter() *BitmapWriter {
+	return &BitmapWriter{answer: NewBitmap(), key: -1, writer: roaring.NewBitmapWriter()}
+}
+
+// Add adds the integer x to the bitmap being built.
+func (w *BitmapWriter) Add(x uint64) {
+	key := int64(highbits(x))
+	if key != w.key {
+		if key < w.key {
+			w.answer.Add(x)
+			return
+		}
+		w.Flush()
+		w.key = key
+	}
+	w.writer.Add(lowbits(x))
+}
+
+// AddMany adds all the integers of dat to the bitmap being built.
+func (w *BitmapWriter) AddMany(dat []uint64) {
+	for _, x := range dat {
+		w.Add(x)
+	}
+}
+
+// AddRange adds the integers in [rangeStart, rangeEnd) to the bitmap being built.
+func (w *BitmapWriter) AddRange(rangeStart, rangeEnd uint64) {
+	if rangeStart >= rangeEnd {
+		return
+	}
+	if int64(highbits(rangeStart)) < w.key {
+		// out of order, fall back to the bitmap itself for the keys before the current one
+		end := uint64(w.key) << 32
+		if rangeEnd < end {
+			end = rangeEnd
+		}
+		w.answer.AddRange(rangeStart, end)
+		rangeStart = end
+		if rangeStart >= rangeEnd {
+			return
+		}
+	}
+
+	hbStart := highbits(rangeStart)
+	hbLast := highbits(rangeEnd - 1)
+	for hb := uint64(hbStart); hb <= uint64(hbLast); hb++ {
+		containerStart := uint64(0)
+		if hb == uint64(hbStart) {
+			containerStart = uint64(lowbits(rangeStart))
+		}
+		containerLast := uint64(maxLowBit)
+		if hb == uint64(hbLast) {
+			containerLast = uint64(lowbits(rangeEnd - 1))
+		}
+		if int64(hb) != w.key {
+			w.Flush()
+			w.key = int64(hb)
+		}
+		w.writer.AddRange(containerStart, containerLast+1)
+	}
+}
+
+// Flush adds the buffered values to the bitmap. It is called automatically
+// when needed, and only has to be called explicitly before accessing the
+// bitmap returned by Get while values are still being added.
+func (w *BitmapWriter) Flush() {
+	if w.key < 0 {
+		return
+	}
+	c := w.writer.Get()
+	w.writer.Reset()
+	if !c.IsEmpty() {
+		ra := &w.answer.highlowcontainer
+		i := ra.getIndex(uint32(w.key))
+		if i >= 0 {
+			ra.getWritableContainerAtIndex(i).Or(c)
+		} else if -i-1 == ra.size() {
+			ra.appendContainer(uint32(w.key), c, false)
+		} else {
+			ra.insertNewKeyValueAt(-i-1, uint32(w.key), c)
+		}
+	}
+	w.key = -1
+}
+
+// Get flushes the buffered values and returns the bitmap being built.
+// The writer may still be used afterwards, in which case it keeps adding
+// values to the same bitmap.
+func (w *BitmapWriter) Get() *Bitmap {
+	w.Flush()
+	return w.answer
+}
+
+// Reset discards the buffered values and makes the writer build a new,
+// empty bitmap. Previously returned bitmaps are not affected.
+func (w *BitmapWriter) Reset() {
+	w.answer = NewBitmap()
+	w.key = -1
+	w.writer.Reset()
+}
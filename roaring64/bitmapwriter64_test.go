@@ -0,0 +1,55 @@
+package roaring64
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitmapWriterSorted64(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	expected := NewBitmap()
+	w := NewBitmapWriter()
+	x := uint64(0)
+	for i := 0; i < 100000; i++ {
+		if i%10000 == 0 {
+			x += 1 << 32
+		}
+		x += uint64(1 + r.Intn(100))
+		expected.Add(x)
+		w.Add(x)
+	}
+	rb := w.Get()
+	assert.True(t, expected.Equals(rb))
+	assert.Equal(t, expected.ToArray(), rb.ToArray())
+}
+
+func TestBitmapWriterOutOfOrder64(t *testing.T) {
+	values := []uint64{10, 5 << 32, 5, 1 << 40, 3 << 32, 1<<40 - 1, 1<<40 + 1, 70}
+	w := NewBitmapWriter()
+	w.AddMany(values)
+	w.AddRange(1<<32-5, 2<<32+5)
+	w.AddRange(1<<40+100, 1<<40+200)
+	w.AddRange(1<<40+150, 1<<40+300)
+
+	expected := BitmapOf(values...)
+	expected.AddRange(1<<32-5, 2<<32+5)
+	expected.AddRange(1<<40+100, 1<<40+300)
+	assert.True(t, expected.Equals(w.Get()))
+}
+
+func TestBitmapWriterReuse64(t *testing.T) {
+	w := NewBitmapWriter()
+	w.AddMany([]uint64{1, 1 << 33, 1 << 34})
+	first := w.Get()
+	w.Reset()
+	w.Add(7)
+	second := w.Get()
+
+	assert.True(t, BitmapOf(1, 1<<33, 1<<34).Equals(first))
+	assert.True(t, BitmapOf(7).Equals(second))
+
+	w.Add(1 << 50)
+	assert.True(t, BitmapOf(7, 1<<50).Equals(w.Get()))
+}
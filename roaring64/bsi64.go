@@ -1,8 +1,14 @@
 package roaring64
 
 import (
+	"encoding/binary"
+	"fmt"
+	"github.com/RoaringBitmap/roaring"
+	"io"
+	"math"
 	"math/bits"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -13,11 +19,16 @@ import (
 // of a single value for a given column ID.  Another usage scenario involves
 // storage of high cardinality values.
 //
+// Values are stored in two's complement: the bit slices hold the low bits
+// of the values, and the bits above them, which are all copies of the sign,
+// are stored once in a bitmap of the columns holding negative values.
+//
 // It depends upon the bitmap libraries.  It is not thread safe, so
 // upstream concurrency guards must be provided.
 type BSI struct {
 	bA           []*Bitmap
 	eBM          *Bitmap // Existence BitMap
+	nBM          *Bitmap // Negative BitMap, the sign of the values
 	MaxValue     int64
 	MinValue     int64
 	runOptimized bool
@@ -27,11 +38,24 @@ type BSI struct {
 // then the underlying BSI will be automatically sized.
 func NewBSI(maxValue int64, minValue int64) *BSI {
 
-	ba := make([]*Bitmap, bits.Len64(uint64(maxValue)))
+	n := valueBits(maxValue)
+	if m := valueBits(minValue); m > n {
+		n = m
+	}
+	ba := make([]*Bitmap, n)
 	for i := 0; i < len(ba); i++ {
 		ba[i] = NewBitmap()
 	}
-	return &BSI{bA: ba, eBM: NewBitmap(), MaxValue: maxValue, MinValue: minValue}
+	return &BSI{bA: ba, eBM: NewBitmap(), nBM: NewBitmap(), MaxValue: maxValue, MinValue: minValue}
+}
+
+// maxBitCount is the number of bit slices needed to store any int64 value
+// besides its sign.
+const maxBitCount = 63
+
+// valueBits returns the number of bits needed to store value besides its sign.
+func valueBits(value int64) int {
+	return bits.Len64(uint64(value ^ (value >> 63)))
 }
 
 // NewDefaultBSI constructs an auto-sized BSI
@@ -42,6 +66,7 @@ func NewDefaultBSI() *BSI {
 // RunOptimize attempts to further compress the runs of consecutive values found in the bitmap
 func (b *BSI) RunOptimize() {
 	b.eBM.RunOptimize()
+	b.nBM.RunOptimize()
 	for i := 0; i < len(b.bA); i++ {
 		b.bA[i].RunOptimize()
 	}
@@ -70,23 +95,30 @@ func (b *BSI) GetCardinality() uint64 {
 }
 
 // BitCount returns the number of bits needed to represent values.
+// The sign of negative values is stored separately and is not counted.
 func (b *BSI) BitCount() int {
 
 	return len(b.bA)
 }
 
+// grow adds bit slices until there are n of them. The bits above the
+// slices being copies of the sign, the new slices are copies of nBM.
+func (b *BSI) grow(n int) {
+	for b.BitCount() < n {
+		newBm := b.nBM.Clone()
+		if b.runOptimized {
+			newBm.RunOptimize()
+		}
+		b.bA = append(b.bA, newBm)
+	}
+}
+
 // SetValue sets a value for a given columnID.
 func (b *BSI) SetValue(columnID uint64, value int64) {
 
 	// If max/min values are set to zero then automatically determine bit array size
 	if b.MaxValue == 0 && b.MinValue == 0 {
-		ba := make([]*Bitmap, bits.Len64(uint64(value)))
-		for i := len(ba) - b.BitCount(); i > 0; i-- {
-			b.bA = append(b.bA, NewBitmap())
-			if b.runOptimized {
-				b.bA[i].RunOptimize()
-			}
-		}
+		b.grow(valueBits(value))
 	}
 
 	var wg sync.WaitGroup
@@ -103,6 +135,11 @@ func (b *BSI) SetValue(columnID uint64, value int64) {
 		}(i)
 	}
 	wg.Wait()
+	if value < 0 {
+		b.nBM.Add(uint64(columnID))
+	} else {
+		b.nBM.Remove(uint64(columnID))
+	}
 	b.eBM.Add(uint64(columnID))
 }
 
@@ -118,9 +155,130 @@ func (b *BSI) GetValue(columnID uint64) (int64, bool) {
 			value |= (1 << uint64(i))
 		}
 	}
+	if b.nBM.Contains(uint64(columnID)) {
+		// the bits above the slices are set
+		value |= -1 << uint64(b.BitCount())
+	}
 	return int64(value), exists
 }
 
+// SetValues sets the values of the given column IDs, as SetValue does for each of them,
+// the value of columnIDs[i] being values[i].  If a column ID is given more than once, its
+// last value is set.  The bit slices are built in one pass over the values, which is much
+// faster than calling SetValue for each value, and faster still if the column IDs are
+// sorted in ascending order.
+func (b *BSI) SetValues(columnIDs []uint64, values []int64) error {
+
+	if len(columnIDs) != len(values) {
+		return fmt.Errorf("error in BSI.SetValues: %d column IDs but %d values", len(columnIDs), len(values))
+	}
+	columnIDs, values = sortedColumns(columnIDs, values)
+	bitCount := b.BitCount()
+	if b.MaxValue == 0 && b.MinValue == 0 {
+		bitCount = 0
+	}
+	batch := loadSorted(columnIDs, values, bitCount)
+	batch.runOptimized = b.runOptimized
+
+	// clear the values being replaced, then merge the new ones
+	if b.eBM.Intersects(batch.eBM) {
+		var wg sync.WaitGroup
+		for _, bm := range append([]*Bitmap{b.eBM, b.nBM}, b.bA...) {
+			wg.Add(1)
+			go func(bm *Bitmap) {
+				defer wg.Done()
+				bm.AndNot(batch.eBM)
+			}(bm)
+		}
+		wg.Wait()
+	}
+	b.ParOr(0, batch)
+	return nil
+}
+
+// NewBSIFromSortedValues creates an automatically sized BSI holding the given values, the
+// value of columnIDs[i] being values[i].  The column IDs must be in strictly ascending
+// order: the bitmaps are then built in one pass, by appending the column IDs in order.
+func NewBSIFromSortedValues(columnIDs []uint64, values []int64) (*BSI, error) {
+
+	if len(columnIDs) != len(values) {
+		return nil, fmt.Errorf("error in NewBSIFromSortedValues: %d column IDs but %d values", len(columnIDs), len(values))
+	}
+	for i := 1; i < len(columnIDs); i++ {
+		if columnIDs[i] <= columnIDs[i-1] {
+			return nil, fmt.Errorf("error in NewBSIFromSortedValues: column IDs are not in strictly ascending order at index %d", i)
+		}
+	}
+	return loadSorted(columnIDs, values, 0), nil
+}
+
+// sortedColumns returns the column IDs in strictly ascending order, with their values.  The
+// last value is kept for the column IDs given more than once.  The input is returned as is
+// if it is already sorted.
+func sortedColumns(columnIDs []uint64, values []int64) ([]uint64, []int64) {
+
+	sorted := true
+	for i := 1; i < len(columnIDs) && sorted; i++ {
+		sorted = columnIDs[i] > columnIDs[i-1]
+	}
+	if sorted {
+		return columnIDs, values
+	}
+
+	order := make([]int, len(columnIDs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return columnIDs[order[i]] < columnIDs[order[j]] })
+	sortedIDs := make([]uint64, 0, len(columnIDs))
+	sortedValues := make([]int64, 0, len(values))
+	for _, i := range order {
+		if n := len(sortedIDs); n > 0 && sortedIDs[n-1] == columnIDs[i] {
+			sortedValues[n-1] = values[i]
+			continue
+		}
+		sortedIDs = append(sortedIDs, columnIDs[i])
+		sortedValues = append(sortedValues, values[i])
+	}
+	return sortedIDs, sortedValues
+}
+
+// loadSorted creates a BSI holding the given values, the column IDs being in strictly
+// ascending order.  If bitCount is zero, the BSI is automatically sized, otherwise only
+// the bitCount low bits of the values are stored, as SetValue does for BSIs of fixed size.
+func loadSorted(columnIDs []uint64, values []int64, bitCount int) *BSI {
+
+	if bitCount == 0 {
+		for _, value := range values {
+			if n := valueBits(value); n > bitCount {
+				bitCount = n
+			}
+		}
+	}
+
+	eBM, nBM := NewBitmapWriter(), NewBitmapWriter()
+	bA := make([]*BitmapWriter, bitCount)
+	for i := range bA {
+		bA[i] = NewBitmapWriter()
+	}
+	mask := uint64(1)<<uint(bitCount) - 1
+	for i, columnID := range columnIDs {
+		eBM.Add(columnID)
+		if values[i] < 0 {
+			nBM.Add(columnID)
+		}
+		for set := uint64(values[i]) & mask; set != 0; set &= set - 1 {
+			bA[bits.TrailingZeros64(set)].Add(columnID)
+		}
+	}
+
+	bsi := &BSI{eBM: eBM.Get(), nBM: nBM.Get(), bA: make([]*Bitmap, bitCount)}
+	for i := range bA {
+		bsi.bA[i] = bA[i].Get()
+	}
+	return bsi
+}
+
 type action func(t *task, batch []uint64, resultsChan chan *Bitmap, wg *sync.WaitGroup)
 
 func parallelExecutor(parallelism int, t *task, e action,
@@ -268,83 +426,35 @@ func compareValue(e *task, batch []uint64, resultsChan chan *Bitmap, wg *sync.Wa
 
 	for i := 0; i < len(batch); i++ {
 		cID := batch[i]
-		eq1, eq2 := true, true
-		lt1, lt2, gt1 := false, false, false
-		for j := e.bsi.BitCount() - 1; j >= 0; j-- {
-			sliceContainsBit := e.bsi.bA[j].Contains(cID)
-
-			if uint64(e.valueOrStart)&(1<<uint64(j)) > 0 {
-				// BIT in value is SET
-				if !sliceContainsBit {
-					if eq1 {
-						if e.op == LT || e.op == LE {
-							lt1 = true
-						}
-						eq1 = false
-						break
-					}
-				}
-			} else {
-				// BIT in value is CLEAR
-				if sliceContainsBit {
-					if eq1 {
-						if e.op == GT || e.op == GE || e.op == RANGE {
-							gt1 = true
-						}
-						eq1 = false
-						if e.op != RANGE {
-							break
-						}
-					}
-				}
-			}
-
-			if e.op == RANGE && uint64(e.end)&(1<<uint64(j)) > 0 {
-				// BIT in value is SET
-				if !sliceContainsBit {
-					if eq2 {
-						lt2 = true
-						eq2 = false
-					}
-				}
-			} else {
-				// BIT in value is CLEAR
-				if sliceContainsBit {
-					if eq2 {
-						eq2 = false
-					}
-				}
-			}
-
-		}
+		cmp := e.bsi.compareColumn(cID, e.valueOrStart)
 
 		switch e.op {
 		case LT:
-			if lt1 {
+			if cmp < 0 {
 				results.Add(cID)
 			}
 		case LE:
-			if eq1 || lt1 {
+			if cmp <= 0 {
 				results.Add(cID)
 			}
 		case EQ:
-			if eq1 {
+			if cmp == 0 {
 				results.Add(cID)
 			}
 		case GE:
-			if eq1 || gt1 {
+			if cmp >= 0 {
 				results.Add(cID)
 			}
 		case GT:
-			if gt1 {
+			if cmp > 0 {
 				results.Add(cID)
 			}
 		case RANGE:
-			if (eq1 || gt1) && (eq2 || lt2) {
+			if cmp >= 0 && e.bsi.compareColumn(cID, e.end) <= 0 {
 				results.Add(cID)
 			}
 		default:
-			if eq1 {
+			if cmp == 0 {
 				results.Add(cID)
 			}
 		}
@@ -353,8 +463,43 @@ func compareValue(e *task, batch []uint64, resultsChan chan *Bitmap, wg *sync.Wa
 	resultsChan <- results
 }
 
+// compareColumn compares the value of the column cID with value. The result
+// is 0 if they are equal, -1 if the value of the column is smaller, and +1
+// if it is greater.
+func (b *BSI) compareColumn(cID uint64, value int64) int {
+	n := b.BitCount()
+	if n < maxBitCount {
+		// value is out of the range of the BSI
+		if value >= 1<<uint(n) {
+			return -1
+		} else if value < -1<<uint(n) {
+			return 1
+		}
+	}
+
+	// values of different signs, then values of the same sign compare as their low bits
+	negative := b.nBM.Contains(cID)
+	if negative != (value < 0) {
+		if negative {
+			return -1
+		}
+		return 1
+	}
+	for j := n - 1; j >= 0; j-- {
+		sliceContainsBit := b.bA[j].Contains(cID)
+		if sliceContainsBit != (uint64(value)&(1<<uint64(j)) > 0) {
+			if sliceContainsBit {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
 // Sum all values contained within the foundSet.   As a convenience, the cardinality of the foundSet
-// is also returned (for calculating the average).
+// is also returned (for calculating the average). The sum wraps around if it
+// does not fit in an int64.
 //
 func (b *BSI) Sum(foundSet *Bitmap) (sum int64, count uint64) {
 
@@ -368,9 +513,430 @@ func (b *BSI) Sum(foundSet *Bitmap) (sum int64, count uint64) {
 		}(i)
 	}
 	wg.Wait()
+	// negative values have all the bits above the slices set, which amounts to subtracting 2^BitCount
+	sum -= int64(foundSet.AndCardinality(b.nBM) << uint(b.BitCount()))
 	return
 }
 
+// MinMax returns the smallest and the largest values of the columns of foundSet.
+// If foundSet is nil, all the columns are considered.  The returned bool is false
+// if none of the columns has a value.
+func (b *BSI) MinMax(foundSet *Bitmap) (min, max int64, ok bool) {
+
+	candidates := b.candidates(foundSet)
+	if candidates.IsEmpty() {
+		return 0, 0, false
+	}
+	min, _ = b.GetValue(b.extremeColumns(candidates, false).Minimum())
+	max, _ = b.GetValue(b.extremeColumns(candidates, true).Minimum())
+	return min, max, true
+}
+
+// TopK returns the columns of foundSet holding the k largest values.  If foundSet
+// is nil, all the columns are considered.  Ties at the k-th value are broken in
+// favor of the smallest column IDs, so that exactly k columns are returned unless
+// fewer columns have a value.
+func (b *BSI) TopK(k uint64, foundSet *Bitmap) *Bitmap {
+
+	return b.topK(k, b.candidates(foundSet), true)
+}
+
+// BottomK returns the columns of foundSet holding the k smallest values.  If foundSet
+// is nil, all the columns are considered.  Ties are broken as in TopK.
+func (b *BSI) BottomK(k uint64, foundSet *Bitmap) *Bitmap {
+
+	return b.topK(k, b.candidates(foundSet), false)
+}
+
+// candidates returns the columns of foundSet which have a value.
+func (b *BSI) candidates(foundSet *Bitmap) *Bitmap {
+
+	if foundSet == nil {
+		return b.eBM.Clone()
+	}
+	return And(b.eBM, foundSet)
+}
+
+// preferred returns the columns of candidates whose bit i is the one of the
+// largest (or smallest) values.  Bit BitCount() is the sign.
+func (b *BSI) preferred(i int, candidates *Bitmap, largest bool) *Bitmap {
+
+	if i == b.BitCount() {
+		if largest {
+			return AndNot(candidates, b.nBM)
+		}
+		return And(candidates, b.nBM)
+	}
+	if largest {
+		return And(candidates, b.bA[i])
+	}
+	return AndNot(candidates, b.bA[i])
+}
+
+// extremeColumns returns the columns of candidates holding the largest (or smallest) value.
+func (b *BSI) extremeColumns(candidates *Bitmap, largest bool) *Bitmap {
+
+	for i := b.BitCount(); i >= 0; i-- {
+		if p := b.preferred(i, candidates, largest); !p.IsEmpty() {
+			candidates = p
+		}
+	}
+	return candidates
+}
+
+// topK walks the bit slices from the sign down, moving to found the columns whose
+// values are certainly among the k largest (or smallest) ones, and narrowing the
+// candidates to the columns which may still be.
+func (b *BSI) topK(k uint64, candidates *Bitmap, largest bool) *Bitmap {
+
+	if candidates.GetCardinality() <= k {
+		return candidates
+	}
+	found := NewBitmap()
+	for i := b.BitCount(); i >= 0; i-- {
+		p := b.preferred(i, candidates, largest)
+		card := found.GetCardinality() + p.GetCardinality()
+		if card > k {
+			candidates = p
+			continue
+		}
+		found.Or(p)
+		if card == k {
+			return found
+		}
+		candidates.AndNot(p)
+	}
+	// the remaining candidates all hold the same value
+	iter := candidates.Iterator()
+	for n := found.GetCardinality(); n < k; n++ {
+		found.Add(iter.Next())
+	}
+	return found
+}
+
+// Quantile returns the q-quantile of the values of the columns of foundSet, that is the
+// smallest value such that a fraction q of the values are lower or equal (nearest rank).
+// If foundSet is nil, all the columns are considered.  The returned bool is false if none
+// of the columns has a value.  Quantile panics if q is not within [0, 1].
+func (b *BSI) Quantile(q float64, foundSet *Bitmap) (int64, bool) {
+
+	if q < 0 || q > 1 {
+		panic("quantile is not within [0, 1]")
+	}
+	candidates := b.candidates(foundSet)
+	card := candidates.GetCardinality()
+	if card == 0 {
+		return 0, false
+	}
+	return b.valueAtRank(nearestRank(q, card), candidates), true
+}
+
+// nearestRank returns the rank, starting at 0, of the q-quantile of card values.  q*card
+// is rounded to the nearest integer when it is only off by floating-point errors, so that
+// the 0.07-quantile of 100 values is the 7th value although 0.07*100 is 7.000000000000001.
+func nearestRank(q float64, card uint64) uint64 {
+	x := q * float64(card)
+	if r := math.Round(x); math.Abs(x-r) <= x*1e-12 {
+		x = r
+	}
+	rank := uint64(math.Ceil(x))
+	if rank == 0 {
+		return 0
+	}
+	if rank > card {
+		return card - 1
+	}
+	return rank - 1
+}
+
+// Median returns the median of the values of the columns of foundSet, the mean of the two
+// middle values if there is an even number of them.  If foundSet is nil, all the columns
+// are considered.  The returned bool is false if none of the columns has a value.
+func (b *BSI) Median(foundSet *Bitmap) (float64, bool) {
+
+	candidates := b.candidates(foundSet)
+	card := candidates.GetCardinality()
+	if card == 0 {
+		return 0, false
+	}
+	if card%2 == 1 {
+		return float64(b.valueAtRank(card/2, candidates.Clone())), true
+	}
+	low := b.valueAtRank(card/2-1, candidates.Clone())
+	high := b.valueAtRank(card/2, candidates)
+	return float64(low)/2 + float64(high)/2, true
+}
+
+// Histogram counts the values of the columns of foundSet in the buckets delimited by
+// bucketBoundaries, which must be sorted in ascending order.  The first count is the one
+// of the values lower than bucketBoundaries[0], the count i is the one of the values
+// within [bucketBoundaries[i-1], bucketBoundaries[i]), and the last one is the one of the
+// values greater or equal to the last boundary.  If foundSet is nil, all the columns are
+// considered.
+func (b *BSI) Histogram(bucketBoundaries []int64, foundSet *Bitmap) []uint64 {
+
+	candidates := b.candidates(foundSet)
+	counts := make([]uint64, len(bucketBoundaries)+1)
+	var below uint64
+	for i, boundary := range bucketBoundaries {
+		if i > 0 && boundary < bucketBoundaries[i-1] {
+			panic("bucket boundaries are not sorted")
+		}
+		lt := b.lessThan(boundary, candidates).GetCardinality()
+		counts[i] = lt - below
+		below = lt
+	}
+	counts[len(bucketBoundaries)] = candidates.GetCardinality() - below
+	return counts
+}
+
+// valueAtRank returns the value of rank r, counting from 0, among the values of
+// candidates sorted in ascending order.  It narrows candidates down to the columns
+// holding that value, from the sign down to the least significant bit.
+func (b *BSI) valueAtRank(r uint64, candidates *Bitmap) int64 {
+
+	var value int64
+	negative := And(candidates, b.nBM)
+	if card := negative.GetCardinality(); r < card {
+		candidates = negative
+		value = -1 << uint(b.BitCount())
+	} else {
+		r -= card
+		candidates.AndNot(b.nBM)
+	}
+	for i := b.BitCount() - 1; i >= 0; i-- {
+		zeros := AndNot(candidates, b.bA[i])
+		if card := zeros.GetCardinality(); r < card {
+			candidates = zeros
+		} else {
+			r -= card
+			candidates.And(b.bA[i])
+			value |= 1 << uint(i)
+		}
+	}
+	return value
+}
+
+// lessThan returns the columns of candidates whose values are lower than value.
+func (b *BSI) lessThan(value int64, candidates *Bitmap) *Bitmap {
+
+	n := b.BitCount()
+	if n < maxBitCount {
+		// value is out of the range of the BSI
+		if value >= 1<<uint(n) {
+			return candidates.Clone()
+		} else if value < -1<<uint(n) {
+			return NewBitmap()
+		}
+	}
+
+	// negative values are lower than the non-negative ones, then values of the same sign compare as their low bits
+	var lt, eq *Bitmap
+	if value < 0 {
+		lt = NewBitmap()
+		eq = And(candidates, b.nBM)
+	} else {
+		lt = And(candidates, b.nBM)
+		eq = AndNot(candidates, b.nBM)
+	}
+	for i := n - 1; i >= 0; i-- {
+		if uint64(value)&(1<<uint64(i)) > 0 {
+			lt.Or(AndNot(eq, b.bA[i]))
+			eq.And(b.bA[i])
+		} else {
+			eq.AndNot(b.bA[i])
+		}
+	}
+	return lt
+}
+
+// GroupStats holds the aggregates of the values of a group of columns.
+type GroupStats struct {
+	Sum   int64 // wraps around if it does not fit in an int64
+	Count uint64
+	Min   int64
+	Max   int64
+}
+
+// SumBy aggregates the values of the columns of foundSet, per group of columns.  If foundSet
+// is nil, all the columns are considered.  The bit slices are intersected with foundSet once
+// and shared by all the groups.  The slices, then the groups, are processed in parallel, the
+// parallelism parameter indicating the number of CPU threads to be applied for processing.
+// A value of zero indicates that all available CPU resources will be potentially utilized.
+// Groups without any value are returned with a zero Count.
+func (b *BSI) SumBy(parallelism int, groups map[string]*Bitmap, foundSet *Bitmap) map[string]GroupStats {
+
+	keys := make([]string, 0, len(groups))
+	bitmaps := make([]*Bitmap, 0, len(groups))
+	for key, group := range groups {
+		keys = append(keys, key)
+		bitmaps = append(bitmaps, group)
+	}
+	stats := b.sumBy(parallelism, bitmaps, b.candidates(foundSet))
+	results := make(map[string]GroupStats, len(groups))
+	for i, key := range keys {
+		results[key] = stats[i]
+	}
+	return results
+}
+
+// SumByBSI aggregates the values of the columns of foundSet, grouped by the values of the
+// same columns in groupKeys.  If foundSet is nil, all the columns are considered.  Columns
+// without a value in groupKeys are ignored.  The groups are found by splitting the columns
+// on the bit slices of groupKeys, and are then aggregated as in SumBy.  The parallelism
+// parameter indicates the number of CPU threads to be applied for processing.  A value of
+// zero indicates that all available CPU resources will be potentially utilized.
+func (b *BSI) SumByBSI(parallelism int, groupKeys *BSI, foundSet *Bitmap) map[int64]GroupStats {
+
+	candidates := b.candidates(foundSet)
+	candidates.And(groupKeys.eBM)
+	keys, groups := groupKeys.valueGroups(candidates)
+	stats := b.sumBy(parallelism, groups, candidates)
+	results := make(map[int64]GroupStats, len(keys))
+	for i, key := range keys {
+		results[key] = stats[i]
+	}
+	return results
+}
+
+// valueGroups partitions columns, which must all have a value, by value.  The columns are
+// split on the sign, then on every bit slice from the most significant one down, so that
+// the work depends on the number of distinct values rather than on the number of columns.
+func (b *BSI) valueGroups(columns *Bitmap) (values []int64, groups []*Bitmap) {
+
+	type group struct {
+		columns *Bitmap
+		value   int64
+	}
+	var current []group
+	if negative := And(columns, b.nBM); !negative.IsEmpty() {
+		current = append(current, group{negative, -1 << uint(b.BitCount())})
+	}
+	if positive := AndNot(columns, b.nBM); !positive.IsEmpty() {
+		current = append(current, group{positive, 0})
+	}
+	for i := b.BitCount() - 1; i >= 0; i-- {
+		next := make([]group, 0, len(current))
+		for _, g := range current {
+			set := And(g.columns, b.bA[i])
+			if !set.IsEmpty() {
+				next = append(next, group{set, g.value | 1<<uint(i)})
+			}
+			if set.GetCardinality() < g.columns.GetCardinality() {
+				next = append(next, group{AndNot(g.columns, b.bA[i]), g.value})
+			}
+		}
+		current = next
+	}
+	for _, g := range current {
+		values = append(values, g.value)
+		groups = append(groups, g.columns)
+	}
+	return values, groups
+}
+
+// sumBy aggregates the values of the columns of candidates, which must all have a value,
+// per group.  Every bit slice is intersected with candidates once, and counted against all
+// the groups in a single pass; the minimum and maximum of every group are then found by
+// narrowing its columns over the shared intersections.
+func (b *BSI) sumBy(parallelism int, groups []*Bitmap, candidates *Bitmap) []GroupStats {
+
+	bitCount := b.BitCount()
+	// slices holds the bit slices restricted to candidates, the sign last
+	slices := make([]*Bitmap, bitCount+1)
+	// counts[i][g] is the number of columns of group g with bit i set
+	counts := make([][]uint64, bitCount+1)
+	parallelRange(parallelism, bitCount+1, func(i int) {
+		if i == bitCount {
+			slices[i] = And(b.nBM, candidates)
+		} else {
+			slices[i] = And(b.bA[i], candidates)
+		}
+		counts[i] = make([]uint64, len(groups))
+		if slices[i].IsEmpty() {
+			return
+		}
+		for g, group := range groups {
+			counts[i][g] = slices[i].AndCardinality(group)
+		}
+	})
+
+	stats := make([]GroupStats, len(groups))
+	parallelRange(parallelism, len(groups), func(g int) {
+		columns := And(candidates, groups[g])
+		s := GroupStats{Count: columns.GetCardinality()}
+		if s.Count == 0 {
+			return
+		}
+		for i := 0; i < bitCount; i++ {
+			s.Sum += int64(counts[i][g] << uint(i))
+		}
+		s.Sum -= int64(counts[bitCount][g] << uint(bitCount))
+		s.Min = extremeValue(slices, columns, false)
+		s.Max = extremeValue(slices, columns, true)
+		stats[g] = s
+	})
+	return stats
+}
+
+// extremeValue returns the largest (or smallest) value of columns, given the bit slices
+// restricted to a superset of columns, the sign last.  The value is built from the bits
+// chosen while narrowing the columns, as in extremeColumns.
+func extremeValue(slices []*Bitmap, columns *Bitmap, largest bool) int64 {
+
+	bitCount := len(slices) - 1
+	var value int64
+	if largest {
+		if p := AndNot(columns, slices[bitCount]); !p.IsEmpty() {
+			columns = p
+		} else {
+			value = -1 << uint(bitCount)
+		}
+	} else if p := And(columns, slices[bitCount]); !p.IsEmpty() {
+		columns = p
+		value = -1 << uint(bitCount)
+	}
+	for i := bitCount - 1; i >= 0; i-- {
+		if largest {
+			if p := And(columns, slices[i]); !p.IsEmpty() {
+				columns = p
+				value |= 1 << uint(i)
+			}
+		} else if p := AndNot(columns, slices[i]); !p.IsEmpty() {
+			columns = p
+		} else {
+			value |= 1 << uint(i)
+		}
+	}
+	return value
+}
+
+// parallelRange calls f for every integer of [0, n) on the given number of goroutines, or
+// on as many goroutines as there are CPUs if parallelism is zero.
+func parallelRange(parallelism, n int, f func(i int)) {
+
+	if parallelism == 0 {
+		parallelism = runtime.NumCPU()
+	}
+	indexes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 // Transpose calls b.IntersectAndTranspose(0, b.eBM)
 func (b *BSI) Transpose() *Bitmap {
 	return b.IntersectAndTranspose(0, b.eBM)
@@ -419,13 +985,7 @@ func (b *BSI) ParOr(parallelism int, bsis ...*BSI) {
 	}
 
 	// Make sure we have enough bit slices
-	for bits > b.BitCount() {
-		newBm := NewBitmap()
-		if b.runOptimized {
-			newBm.RunOptimize()
-		}
-		b.bA = append(b.bA, newBm)
-	}
+	b.grow(bits)
 
 	a := make([][]*Bitmap, bits)
 	for i := range a {
@@ -434,10 +994,8 @@ func (b *BSI) ParOr(parallelism int, bsis ...*BSI) {
 			if len(x.bA) > i {
 				a[i] = append(a[i], x.bA[i])
 			} else {
-				a[i] = []*Bitmap{NewBitmap()}
-				if b.runOptimized {
-					a[i][0].RunOptimize()
-				}
+				// the bits above the slices are copies of the sign
+				a[i] = append(a[i], x.nBM)
 			}
 		}
 	}
@@ -465,12 +1023,23 @@ func (b *BSI) ParOr(parallelism int, bsis ...*BSI) {
 	x := []*Bitmap{b.eBM}
 	x = append(x, ebms...)
 	b.eBM = ParOr(parallelism, x...)
+
+	// merge the signs
+	x = []*Bitmap{b.nBM}
+	for _, bsi := range bsis {
+		x = append(x, bsi.nBM)
+	}
+	b.nBM = ParOr(parallelism, x...)
 }
 
 // UnmarshalBinary de-serialize a BSI.  The value at bitData[0] is the EBM.  Other indices are in least to most
 // significance order starting at bitData[1] (bit position 0).
 func (b *BSI) UnmarshalBinary(bitData [][]byte) error {
 
+	// slices of a previous content beyond those of bitData are dropped
+	if n := len(bitData) - 1; n >= 0 && n < b.BitCount() {
+		b.bA = b.bA[:n]
+	}
 	for i := 1; i < len(bitData); i++ {
 		if bitData == nil || len(bitData[i]) == 0 {
 			continue
@@ -490,6 +1059,16 @@ func (b *BSI) UnmarshalBinary(bitData [][]byte) error {
 		}
 
 	}
+	if b.BitCount() > maxBitCount {
+		// negative values were written as 64-bit two's complement values
+		b.nBM = b.bA[maxBitCount]
+		b.bA = b.bA[:maxBitCount]
+		for b.BitCount() > 0 && b.bA[b.BitCount()-1].Equals(b.nBM) {
+			b.bA = b.bA[:b.BitCount()-1]
+		}
+	} else {
+		b.nBM = NewBitmap()
+	}
 	// First element of bitData is the EBM
 	if bitData[0] == nil {
 		b.eBM = NewBitmap()
@@ -507,14 +1086,23 @@ func (b *BSI) UnmarshalBinary(bitData [][]byte) error {
 	return nil
 }
 
-// MarshalBinary serializes a BSI
+// MarshalBinary serializes a BSI. If there are negative values, all the
+// values are written as 64-bit two's complement values.
 func (b *BSI) MarshalBinary() ([][]byte, error) {
 
 	var err error
-	data := make([][]byte, b.BitCount()+1)
+	n := b.BitCount()
+	if !b.nBM.IsEmpty() {
+		n = maxBitCount + 1
+	}
+	data := make([][]byte, n+1)
 	// Add extra element for EBM (BitCount() + 1)
-	for i := 1; i < b.BitCount()+1; i++ {
-		data[i], err = b.bA[i-1].MarshalBinary()
+	for i := 1; i < n+1; i++ {
+		bm := b.nBM
+		if i-1 < b.BitCount() {
+			bm = b.bA[i-1]
+		}
+		data[i], err = bm.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
@@ -527,6 +1115,291 @@ func (b *BSI) MarshalBinary() ([][]byte, error) {
 	return data, nil
 }
 
+const (
+	// bsiSerialCookie starts the serialized BSIs, "BSI" in ASCII
+	bsiSerialCookie = 0x495342
+	// bsiSerialVersion is the version of the format written by WriteTo
+	bsiSerialVersion = 1
+	// bsiHeaderSize is the size of the header: cookie, version, flags, bit count, min and max values
+	bsiHeaderSize = 3 + 1 + 1 + 1 + 8 + 8
+
+	// bsiRunOptimizedFlag is set if the BSI is run optimized
+	bsiRunOptimizedFlag = 1
+)
+
+// WriteTo writes a serialized version of this BSI to stream.  The format starts with a
+// header recording the version of the format, the bit count, the minimum and maximum
+// values and the flags, followed by the existence bitmap, the bitmap of the negative
+// values and the bit slices from the least significant one, in the format of
+// Bitmap.WriteTo.  All the numbers are little endian.
+func (b *BSI) WriteTo(stream io.Writer) (int64, error) {
+
+	header := make([]byte, bsiHeaderSize)
+	binary.LittleEndian.PutUint32(header, bsiSerialCookie|bsiSerialVersion<<24)
+	if b.runOptimized {
+		header[4] |= bsiRunOptimizedFlag
+	}
+	header[5] = byte(b.BitCount())
+	binary.LittleEndian.PutUint64(header[6:], uint64(b.MinValue))
+	binary.LittleEndian.PutUint64(header[14:], uint64(b.MaxValue))
+	n, err := stream.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	bitmaps := append([]*Bitmap{b.eBM, b.nBM}, b.bA...)
+	for _, bm := range bitmaps {
+		n, err := bm.WriteTo(stream)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads a serialized version of a BSI, written by WriteTo, from stream.
+func (b *BSI) ReadFrom(stream io.Reader) (p int64, err error) {
+
+	header := make([]byte, bsiHeaderSize)
+	n, err := io.ReadFull(stream, header)
+	p = int64(n)
+	if err != nil {
+		return p, fmt.Errorf("error in BSI.ReadFrom: could not read the header: %s", err)
+	}
+	bitmaps, err := b.readHeader(header)
+	if err != nil {
+		return p, err
+	}
+	for _, bm := range bitmaps {
+		n, err := bm.ReadFrom(stream)
+		p += n
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// FromBuffer creates a BSI from its serialized version, written by WriteTo, stored in buf.
+// The bitmaps are created with Bitmap.FromBuffer, referring to buf instead of copying it as
+// much as possible: buf must not be modified while the BSI is in use.
+func (b *BSI) FromBuffer(buf []byte) (p int64, err error) {
+
+	if len(buf) < bsiHeaderSize {
+		return 0, fmt.Errorf("error in BSI.FromBuffer: buffer too small for the header: %d bytes", len(buf))
+	}
+	bitmaps, err := b.readHeader(buf[:bsiHeaderSize])
+	if err != nil {
+		return 0, err
+	}
+	p = bsiHeaderSize
+	for _, bm := range bitmaps {
+		n, err := bm.FromBuffer(buf[p:])
+		p += n
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// readHeader sets up this BSI according to the serialized header, and returns the bitmaps
+// to read in the order in which they were written.
+func (b *BSI) readHeader(header []byte) ([]*Bitmap, error) {
+
+	cookie := binary.LittleEndian.Uint32(header)
+	if cookie&0xFFFFFF != bsiSerialCookie {
+		return nil, fmt.Errorf("error in BSI.readHeader: did not find expected serialCookie in header")
+	}
+	if version := cookie >> 24; version != bsiSerialVersion {
+		return nil, fmt.Errorf("error in BSI.readHeader: unsupported version %d", version)
+	}
+	bitCount := int(header[5])
+	if bitCount > maxBitCount {
+		return nil, fmt.Errorf("error in BSI.readHeader: bit count %d is larger than %d", bitCount, maxBitCount)
+	}
+
+	b.runOptimized = header[4]&bsiRunOptimizedFlag != 0
+	b.MinValue = int64(binary.LittleEndian.Uint64(header[6:]))
+	b.MaxValue = int64(binary.LittleEndian.Uint64(header[14:]))
+	b.eBM = NewBitmap()
+	b.nBM = NewBitmap()
+	b.bA = make([]*Bitmap, bitCount)
+	for i := range b.bA {
+		b.bA[i] = NewBitmap()
+	}
+	return append([]*Bitmap{b.eBM, b.nBM}, b.bA...), nil
+}
+
+// javaHeaderSize is the size of the header of the Java format: min and max values, and
+// the run optimized flag
+const javaHeaderSize = 8 + 8 + 1
+
+// WriteToJava writes a serialized version of this BSI to stream, in the format of
+// Roaring64NavigableMapSliceIndex.serialize of the Java library: the minimum and maximum
+// values as big endian longs, the run optimized flag as a byte, the existence bitmap, the
+// number of bit slices as a big endian int, and the bit slices from the least significant
+// one.  The bitmaps are written like Roaring64NavigableMap.serialize in its default, legacy,
+// serialization mode (see writeJavaNavigableMap).  The Java BSIs only hold non-negative
+// values: an error is returned if this BSI has negative values.
+func (b *BSI) WriteToJava(stream io.Writer) (int64, error) {
+
+	if !b.nBM.IsEmpty() {
+		return 0, fmt.Errorf("error in BSI.WriteToJava: negative values are not supported")
+	}
+
+	header := make([]byte, javaHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(b.MinValue))
+	binary.BigEndian.PutUint64(header[8:], uint64(b.MaxValue))
+	if b.runOptimized {
+		header[16] = 1
+	}
+	n, err := stream.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	m, err := writeJavaNavigableMap(stream, b.eBM)
+	written += m
+	if err != nil {
+		return written, err
+	}
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(b.BitCount()))
+	n, err = stream.Write(count)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	for _, bm := range b.bA {
+		m, err := writeJavaNavigableMap(stream, bm)
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFromJava reads a serialized version of a BSI from stream, in the format written by
+// Roaring64NavigableMapSliceIndex.serialize of the Java library (see WriteToJava).  The
+// minimum and maximum values of the Java BSI become the ones of this BSI.
+func (b *BSI) ReadFromJava(stream io.Reader) (p int64, err error) {
+
+	header := make([]byte, javaHeaderSize)
+	n, err := io.ReadFull(stream, header)
+	p = int64(n)
+	if err != nil {
+		return p, fmt.Errorf("error in BSI.ReadFromJava: could not read the header: %s", err)
+	}
+	eBM, m, err := readJavaNavigableMap(stream)
+	p += m
+	if err != nil {
+		return p, err
+	}
+	count := make([]byte, 4)
+	n, err = io.ReadFull(stream, count)
+	p += int64(n)
+	if err != nil {
+		return p, fmt.Errorf("error in BSI.ReadFromJava: could not read the number of bit slices: %s", err)
+	}
+	bitCount := int32(binary.BigEndian.Uint32(count))
+	if bitCount < 0 || bitCount > maxBitCount {
+		return p, fmt.Errorf("error in BSI.ReadFromJava: invalid number of bit slices %d", bitCount)
+	}
+	bA := make([]*Bitmap, bitCount)
+	for i := range bA {
+		bA[i], m, err = readJavaNavigableMap(stream)
+		p += m
+		if err != nil {
+			return p, err
+		}
+	}
+
+	b.MinValue = int64(binary.BigEndian.Uint64(header))
+	b.MaxValue = int64(binary.BigEndian.Uint64(header[8:]))
+	b.runOptimized = header[16] != 0
+	b.eBM = eBM
+	b.nBM = NewBitmap()
+	b.bA = bA
+	return p, nil
+}
+
+// writeJavaNavigableMap writes bm like Roaring64NavigableMap.serialize of the Java library
+// in its legacy serialization mode: the signedLongs flag as a byte (false), the number of
+// 32-bit bitmaps as a big endian int, then for each of them its key, the high 32 bits of its
+// values, as a big endian int followed by the bitmap in the format of roaring.Bitmap.WriteTo.
+func writeJavaNavigableMap(stream io.Writer, bm *Bitmap) (int64, error) {
+
+	header := make([]byte, 1+4)
+	binary.BigEndian.PutUint32(header[1:], uint32(bm.highlowcontainer.size()))
+	n, err := stream.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	key := make([]byte, 4)
+	for i, c := range bm.highlowcontainer.containers {
+		binary.BigEndian.PutUint32(key, bm.highlowcontainer.keys[i])
+		n, err := stream.Write(key)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		m, err := c.WriteTo(stream)
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// readJavaNavigableMap reads a bitmap in the format written by writeJavaNavigableMap.  The
+// 32-bit bitmaps of Java maps with signed longs are not sorted by unsigned keys, so they are
+// inserted at their place.
+func readJavaNavigableMap(stream io.Reader) (*Bitmap, int64, error) {
+
+	header := make([]byte, 1+4)
+	n, err := io.ReadFull(stream, header)
+	p := int64(n)
+	if err != nil {
+		return nil, p, fmt.Errorf("error in readJavaNavigableMap: could not read the header: %s", err)
+	}
+	size := int32(binary.BigEndian.Uint32(header[1:]))
+	if size < 0 {
+		return nil, p, fmt.Errorf("error in readJavaNavigableMap: invalid number of bitmaps %d", size)
+	}
+
+	bm := NewBitmap()
+	keyBuf := make([]byte, 4)
+	for i := int32(0); i < size; i++ {
+		n, err := io.ReadFull(stream, keyBuf)
+		p += int64(n)
+		if err != nil {
+			return nil, p, fmt.Errorf("error in readJavaNavigableMap: could not read key %d: %s", i, err)
+		}
+		key := binary.BigEndian.Uint32(keyBuf)
+		c := roaring.NewBitmap()
+		m, err := c.ReadFrom(stream)
+		p += m
+		if err != nil {
+			return nil, p, err
+		}
+		if c.IsEmpty() {
+			continue
+		}
+		idx := bm.highlowcontainer.getIndex(key)
+		if idx >= 0 {
+			return nil, p, fmt.Errorf("error in readJavaNavigableMap: duplicate key %d", key)
+		}
+		bm.highlowcontainer.insertNewKeyValueAt(-idx-1, key, c)
+	}
+	return bm, p, nil
+}
+
 // BatchEqual returns a bitmap containing the column IDs where the values are contained within the list of values provided.
 func (b *BSI) BatchEqual(parallelism int, values []int64) *Bitmap {
 
@@ -576,6 +1449,7 @@ func (b *BSI) ClearValues(foundSet *Bitmap) {
 	go func() {
 		defer wg.Done()
 		ClearBits(foundSet, b.eBM)
+		ClearBits(foundSet, b.nBM)
 	}()
 	for i := 0; i < b.BitCount(); i++ {
 		wg.Add(1)
@@ -598,6 +1472,8 @@ func (b *BSI) NewBSIRetainSet(foundSet *Bitmap) *BSI {
 		defer wg.Done()
 		newBSI.eBM = b.eBM.Clone()
 		newBSI.eBM.And(foundSet)
+		newBSI.nBM = b.nBM.Clone()
+		newBSI.nBM.And(foundSet)
 	}()
 	for i := 0; i < b.BitCount(); i++ {
 		wg.Add(1)
@@ -617,29 +1493,186 @@ func (b *BSI) Clone() *BSI {
 }
 
 // Add - In-place sum the contents of another BSI with this BSI, column wise.
+// The sums wrap around if they do not fit in an int64.
 func (b *BSI) Add(other *BSI) {
 
 	b.eBM.Or(other.eBM)
-	for i := 0; i < len(other.bA); i++ {
-		b.addDigit(other.bA[i], i)
+	if b.nBM.IsEmpty() && other.nBM.IsEmpty() {
+		for i := 0; i < len(other.bA); i++ {
+			b.addDigit(other.bA[i], i)
+		}
+		return
+	}
+
+	// ripple-carry addition of the sign extended values, with a bit more for the carry
+	n := b.BitCount()
+	if other.BitCount() > n {
+		n = other.BitCount()
+	}
+	if n < maxBitCount {
+		n++
 	}
+	b.grow(n)
+	carry := NewBitmap()
+	for i := 0; i < n; i++ {
+		digit := other.nBM
+		if i < other.BitCount() {
+			digit = other.bA[i]
+		}
+		sum := Xor(b.bA[i], digit)
+		nextCarry := And(b.bA[i], digit)
+		nextCarry.Or(And(carry, sum))
+		sum.Xor(carry)
+		b.bA[i] = sum
+		carry = nextCarry
+	}
+	// the in-place Xor may share containers of its argument, the sign is built anew
+	b.nBM = Xor(Xor(b.nBM, other.nBM), carry)
 }
 
 func (b *BSI) addDigit(foundSet *Bitmap, i int) {
 
 	if i >= len(b.bA) {
-		b.bA = append(b.bA, NewBitmap())
+		if i >= maxBitCount {
+			// overflow, the sign changes
+			b.nBM = Xor(b.nBM, foundSet)
+			return
+		}
+		// the carry reaches the sign bits: negative values become non-negative,
+		// and the others get a new bit
+		b.bA = append(b.bA, Xor(b.nBM, foundSet))
+		b.nBM.AndNot(foundSet)
+		return
 	}
 	carry := And(b.bA[i], foundSet)
-	b.bA[i].Xor(foundSet)
+	b.bA[i] = Xor(b.bA[i], foundSet)
 	if carry.GetCardinality() > 0 {
-		if i+1 >= len(b.bA) {
-			b.bA = append(b.bA, NewBitmap())
-		}
 		b.addDigit(carry, i+1)
 	}
 }
 
+// Subtract - In-place subtract the contents of another BSI from this BSI, column wise.
+// Columns without a value in one of the BSIs count as zero.  The differences wrap around
+// if they do not fit in an int64.
+func (b *BSI) Subtract(other *BSI) {
+
+	negated := other.Clone()
+	negated.Negate()
+	b.Add(negated)
+}
+
+// Negate - In-place negation of the values of a BSI.  The negation of the smallest int64
+// wraps around to itself.
+func (b *BSI) Negate() {
+
+	// -x is the complement of x plus one
+	for i := 0; i < b.BitCount(); i++ {
+		b.bA[i] = Xor(b.bA[i], b.eBM)
+	}
+	b.nBM = Xor(b.nBM, b.eBM)
+	b.IncrementAll()
+}
+
+// MultiplyByConstant - In-place multiplication of the values of a BSI by a constant.
+// The products wrap around if they do not fit in an int64.
+func (b *BSI) MultiplyByConstant(c int64) {
+
+	product := NewDefaultBSI()
+	product.eBM = b.eBM.Clone()
+	product.runOptimized = b.runOptimized
+	// sum the values shifted by the bits set in c, which gives the product modulo 2^64
+	for i := 0; i < 64; i++ {
+		if uint64(c)&(1<<uint(i)) > 0 {
+			product.Add(b.shiftLeft(i))
+		}
+	}
+	b.bA = product.bA
+	b.nBM = product.nBM
+}
+
+// shiftLeft returns a BSI holding the values of b multiplied by 2^n, modulo 2^64.
+func (b *BSI) shiftLeft(n int) *BSI {
+
+	shifted := &BSI{eBM: b.eBM, runOptimized: b.runOptimized}
+	if n+b.BitCount() < maxBitCount {
+		shifted.nBM = b.nBM
+		shifted.bA = make([]*Bitmap, 0, n+b.BitCount())
+		for i := 0; i < n; i++ {
+			shifted.bA = append(shifted.bA, NewBitmap())
+		}
+		shifted.bA = append(shifted.bA, b.bA...)
+		return shifted
+	}
+
+	// the bits shifted beyond the 64th are lost, and the 64th one is the new sign
+	bit := func(i int) *Bitmap {
+		if i < n {
+			return NewBitmap()
+		} else if i-n < b.BitCount() {
+			return b.bA[i-n]
+		}
+		return b.nBM
+	}
+	shifted.nBM = bit(maxBitCount)
+	for i := 0; i < maxBitCount; i++ {
+		shifted.bA = append(shifted.bA, bit(i))
+	}
+	return shifted
+}
+
+// CompareBSI compares the values of this BSI with the ones of another BSI, column wise, and
+// returns the columns of foundSet where the value of this BSI is related to the value of the
+// other one by op.  If foundSet is nil, all the columns are considered.  Only the columns
+// having a value in both BSIs are returned.  CompareBSI panics if op is RANGE.
+func (b *BSI) CompareBSI(op Operation, other *BSI, foundSet *Bitmap) *Bitmap {
+
+	if op == RANGE {
+		panic("RANGE is not supported by CompareBSI")
+	}
+	eq := And(b.eBM, other.eBM)
+	if foundSet != nil {
+		eq.And(foundSet)
+	}
+
+	// negative values are lower than the non-negative ones, then values of the same sign compare as their low bits
+	lt := AndNot(And(eq, b.nBM), other.nBM)
+	gt := AndNot(And(eq, other.nBM), b.nBM)
+	eq.AndNot(Xor(b.nBM, other.nBM))
+	n := b.BitCount()
+	if other.BitCount() > n {
+		n = other.BitCount()
+	}
+	for i := n - 1; i >= 0; i-- {
+		// the bits above the slices are copies of the sign
+		bBits, otherBits := b.nBM, other.nBM
+		if i < b.BitCount() {
+			bBits = b.bA[i]
+		}
+		if i < other.BitCount() {
+			otherBits = other.bA[i]
+		}
+		diff := And(eq, Xor(bBits, otherBits))
+		lt.Or(And(diff, otherBits))
+		gt.Or(And(diff, bBits))
+		eq.AndNot(diff)
+	}
+
+	switch op {
+	case LT:
+		return lt
+	case LE:
+		lt.Or(eq)
+		return lt
+	case GE:
+		gt.Or(eq)
+		return gt
+	case GT:
+		return gt
+	default:
+		return eq
+	}
+}
+
 // TransposeWithCounts is a matrix transpose function that returns a BSI that has a columnID system defined by the values
 // contained within the input BSI.   Given that for BSIs, different columnIDs can have the same value.  TransposeWithCounts
 // is useful for situations where there is a one-to-many relationship between the vectored integer sets.  The resulting BSI
@@ -1,9 +1,13 @@
 package roaring64
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
 )
 
@@ -292,3 +296,775 @@ func TestTransposeWithCounts(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, int64(2), a)
 }
+
+func setupNegative() *BSI {
+
+	bsi := NewBSI(100, -100)
+	// Setup values
+	for i := int(bsi.MinValue); i < int(bsi.MaxValue); i++ {
+		bsi.SetValue(uint64(i-int(bsi.MinValue)), int64(i))
+	}
+	return bsi
+}
+
+func TestSetAndGetNegative(t *testing.T) {
+
+	bsi := NewBSI(999, -1000)
+	assert.Equal(t, 10, bsi.BitCount())
+
+	for _, v := range []int64{-1000, -512, -1, 0, 1, 999} {
+		bsi.SetValue(1, v)
+		gv, ok := bsi.GetValue(1)
+		assert.True(t, ok)
+		assert.Equal(t, v, gv)
+	}
+
+	bsi = NewDefaultBSI()
+	for i, v := range []int64{-1, 1 << 40, -1 << 62, math.MinInt64, math.MaxInt64, 3} {
+		bsi.SetValue(uint64(i), v)
+	}
+	for i, v := range []int64{-1, 1 << 40, -1 << 62, math.MinInt64, math.MaxInt64, 3} {
+		gv, ok := bsi.GetValue(uint64(i))
+		assert.True(t, ok)
+		assert.Equal(t, v, gv)
+	}
+}
+
+func TestCompareValueNegative(t *testing.T) {
+
+	bsi := setupNegative()
+	for _, op := range []Operation{LT, LE, EQ, GE, GT, RANGE} {
+		for _, value := range []int64{math.MinInt64, -300, -100, -51, -1, 0, 1, 50, 99, 300, math.MaxInt64} {
+			end := value + 20
+			if value == math.MaxInt64 {
+				end = value
+			}
+			result := bsi.CompareValue(0, op, value, end, nil)
+			expected := NewBitmap()
+			for i := bsi.MinValue; i < bsi.MaxValue; i++ {
+				if (op == LT && i < value) || (op == LE && i <= value) || (op == EQ && i == value) ||
+					(op == GE && i >= value) || (op == GT && i > value) || (op == RANGE && i >= value && i <= end) {
+					expected.Add(uint64(i - bsi.MinValue))
+				}
+			}
+			assert.True(t, expected.Equals(result), "op %d value %d", op, value)
+		}
+	}
+}
+
+func TestSumNegative(t *testing.T) {
+
+	bsi := setupNegative()
+	sum, count := bsi.Sum(bsi.GetExistenceBitmap())
+	assert.Equal(t, uint64(200), count)
+	assert.Equal(t, int64(-100), sum)
+
+	sum, count = bsi.Sum(BitmapOf(0, 1, 150))
+	assert.Equal(t, uint64(3), count)
+	assert.Equal(t, int64(-100-99+50), sum)
+}
+
+func TestAddNegative(t *testing.T) {
+
+	values := []int64{-100, -1, 0, 1, 100, math.MaxInt64, math.MinInt64, -1 << 40}
+	bsi := NewDefaultBSI()
+	other := NewDefaultBSI()
+	for i, a := range values {
+		for j, b := range values {
+			bsi.SetValue(uint64(i*len(values)+j), a)
+			other.SetValue(uint64(i*len(values)+j), b)
+		}
+	}
+	bsi.Add(other)
+	for i, a := range values {
+		for j, b := range values {
+			v, ok := bsi.GetValue(uint64(i*len(values) + j))
+			assert.True(t, ok)
+			assert.Equal(t, a+b, v, "%d + %d", a, b)
+		}
+	}
+}
+
+func TestAddDoesNotShareContainers(t *testing.T) {
+
+	// columns with different high keys, so that whole containers are moved between bitmaps
+	bsi := NewDefaultBSI()
+	bsi.SetValue(1<<40, -5)
+	other := NewDefaultBSI()
+	other.SetValue(1, -3)
+	other.SetValue(2, -4)
+	other.SetValue(1<<41, 7)
+	bsi.Add(other)
+	bsi.SetValue(1, 100)
+	bsi.SetValue(2, 0)
+
+	for col, expected := range map[uint64]int64{1: -3, 2: -4, 1 << 41: 7} {
+		v, ok := other.GetValue(col)
+		assert.True(t, ok)
+		assert.Equal(t, expected, v, "column %d", col)
+	}
+	for col, expected := range map[uint64]int64{1: 100, 2: 0, 1 << 40: -5, 1 << 41: 7} {
+		v, ok := bsi.GetValue(col)
+		assert.True(t, ok)
+		assert.Equal(t, expected, v, "column %d", col)
+	}
+
+	foundSet := BitmapOf(1, 1<<40)
+	bsi.Increment(foundSet)
+	bsi.SetValue(1<<40, 1)
+	assert.Equal(t, BitmapOf(1, 1<<40), foundSet)
+	v, _ := bsi.GetValue(1)
+	assert.EqualValues(t, 101, v)
+}
+
+func TestIncrementNegative(t *testing.T) {
+
+	bsi := setupNegative()
+	bsi.IncrementAll()
+	for i := bsi.MinValue; i < bsi.MaxValue; i++ {
+		a, _ := bsi.GetValue(uint64(i - bsi.MinValue))
+		assert.Equal(t, i+1, a)
+	}
+
+	bsi = NewDefaultBSI()
+	bsi.SetValue(0, math.MaxInt64)
+	bsi.Increment(BitmapOf(0))
+	a, _ := bsi.GetValue(0)
+	assert.Equal(t, int64(math.MinInt64), a)
+}
+
+func TestBatchEqualNegative(t *testing.T) {
+
+	bsi := setupNegative()
+	result := bsi.BatchEqual(0, []int64{-100, -1, 5, 1000})
+	assert.Equal(t, []uint64{0, 99, 105}, result.ToArray())
+}
+
+func TestMarshalNegative(t *testing.T) {
+
+	bsi := setupNegative()
+	data, err := bsi.MarshalBinary()
+	require.Nil(t, err)
+	assert.Equal(t, 65, len(data))
+
+	newBSI := NewDefaultBSI()
+	require.Nil(t, newBSI.UnmarshalBinary(data))
+	assert.Equal(t, bsi.BitCount(), newBSI.BitCount())
+	for i := bsi.MinValue; i < bsi.MaxValue; i++ {
+		a, ok := newBSI.GetValue(uint64(i - bsi.MinValue))
+		assert.True(t, ok)
+		assert.Equal(t, i, a)
+	}
+}
+
+func TestUnmarshalReuse(t *testing.T) {
+
+	negative, err := setupNegative().MarshalBinary()
+	require.Nil(t, err)
+	positive := NewDefaultBSI()
+	positive.SetValue(1, 5)
+	positive.SetValue(2, 1)
+	data, err := positive.MarshalBinary()
+	require.Nil(t, err)
+
+	// a BSI holding negative values is reused for positive ones
+	bsi := NewDefaultBSI()
+	require.Nil(t, bsi.UnmarshalBinary(negative))
+	require.Nil(t, bsi.UnmarshalBinary(data))
+	assert.Equal(t, positive.BitCount(), bsi.BitCount())
+	assert.EqualValues(t, 2, bsi.GetCardinality())
+	for col, expected := range map[uint64]int64{1: 5, 2: 1} {
+		a, ok := bsi.GetValue(col)
+		assert.True(t, ok)
+		assert.Equal(t, expected, a)
+	}
+	sum, count := bsi.Sum(bsi.GetExistenceBitmap())
+	assert.EqualValues(t, 6, sum)
+	assert.EqualValues(t, 2, count)
+}
+
+func TestParOrNegative(t *testing.T) {
+
+	bsi := NewBSI(10, 0)
+	bsi.SetValue(1, 3)
+	other := NewDefaultBSI()
+	other.SetValue(2, -1)
+	other.SetValue(3, 1<<20)
+	bsi.ParOr(0, other)
+
+	for col, expected := range map[uint64]int64{1: 3, 2: -1, 3: 1 << 20} {
+		a, ok := bsi.GetValue(col)
+		assert.True(t, ok)
+		assert.Equal(t, expected, a)
+	}
+}
+
+func TestMinMax(t *testing.T) {
+
+	bsi := setupNegative()
+	min, max, ok := bsi.MinMax(nil)
+	assert.True(t, ok)
+	assert.Equal(t, int64(-100), min)
+	assert.Equal(t, int64(99), max)
+
+	min, max, ok = bsi.MinMax(BitmapOf(50, 120, 130, 1000))
+	assert.True(t, ok)
+	assert.Equal(t, int64(-50), min)
+	assert.Equal(t, int64(30), max)
+
+	_, _, ok = bsi.MinMax(BitmapOf(1000))
+	assert.False(t, ok)
+	_, _, ok = NewDefaultBSI().MinMax(nil)
+	assert.False(t, ok)
+}
+
+func TestTopK(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		bsi.SetValue(uint64(i), r.Int63n(200)-100)
+	}
+	foundSet := NewBitmap()
+	foundSet.AddRange(100, 2000)
+
+	for _, k := range []uint64{0, 1, 2, 10, 99, 500, 900, 901, 2000} {
+		top := bsi.TopK(k, foundSet)
+		bottom := bsi.BottomK(k, foundSet)
+		if k < 900 {
+			assert.Equal(t, k, top.GetCardinality())
+			assert.Equal(t, k, bottom.GetCardinality())
+		} else {
+			assert.Equal(t, uint64(900), top.GetCardinality())
+			assert.Equal(t, uint64(900), bottom.GetCardinality())
+		}
+		rest := And(foundSet, bsi.GetExistenceBitmap())
+		if top.IsEmpty() || top.GetCardinality() == rest.GetCardinality() {
+			continue
+		}
+		// all the values in the result are at least as large (small) as the others
+		topMin, _, _ := bsi.MinMax(top)
+		_, restMax, _ := bsi.MinMax(AndNot(rest, top))
+		assert.LessOrEqual(t, restMax, topMin)
+		_, bottomMax, _ := bsi.MinMax(bottom)
+		restMin, _, _ := bsi.MinMax(AndNot(rest, bottom))
+		assert.LessOrEqual(t, bottomMax, restMin)
+	}
+}
+
+func TestTopKTies(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	for i := 0; i < 10; i++ {
+		bsi.SetValue(uint64(i), 5)
+	}
+	bsi.SetValue(10, 7)
+	bsi.SetValue(11, -7)
+	assert.Equal(t, []uint64{0, 1, 10}, bsi.TopK(3, nil).ToArray())
+	assert.Equal(t, []uint64{0, 11}, bsi.BottomK(2, nil).ToArray())
+}
+
+func TestQuantile(t *testing.T) {
+
+	bsi := setupNegative()
+	for _, c := range []struct {
+		q        float64
+		expected int64
+	}{{0, -100}, {0.005, -100}, {0.01, -99}, {0.25, -51}, {0.5, -1}, {0.75, 49}, {0.999, 99}, {1, 99}} {
+		v, ok := bsi.Quantile(c.q, nil)
+		assert.True(t, ok)
+		assert.Equal(t, c.expected, v, "q %v", c.q)
+	}
+
+	median, ok := bsi.Median(nil)
+	assert.True(t, ok)
+	assert.Equal(t, -0.5, median)
+	median, ok = bsi.Median(BitmapOf(0, 150, 199))
+	assert.True(t, ok)
+	assert.Equal(t, float64(50), median)
+
+	_, ok = bsi.Quantile(0.5, BitmapOf(1000))
+	assert.False(t, ok)
+	_, ok = bsi.Median(NewBitmap())
+	assert.False(t, ok)
+	assert.Panics(t, func() { bsi.Quantile(1.5, nil) })
+}
+
+func TestQuantilePercents(t *testing.T) {
+
+	// 0.07*100 is 7.000000000000001 in floating point, the 0.07-quantile is still 7
+	bsi := NewDefaultBSI()
+	for i := 1; i <= 100; i++ {
+		bsi.SetValue(uint64(i), int64(i))
+	}
+	v, ok := bsi.Quantile(0.07, nil)
+	assert.True(t, ok)
+	assert.EqualValues(t, 7, v)
+	for p := 1; p <= 100; p++ {
+		v, _ := bsi.Quantile(float64(p)/100, nil)
+		assert.EqualValues(t, p, v, "q %v", float64(p)/100)
+	}
+	v, _ = bsi.Quantile(0.071, nil)
+	assert.EqualValues(t, 8, v)
+}
+
+func TestQuantileDuplicates(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	r := rand.New(rand.NewSource(0))
+	values := make([]int64, 1001)
+	for i := range values {
+		values[i] = r.Int63n(100) - 50
+		bsi.SetValue(uint64(i), values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 1} {
+		v, ok := bsi.Quantile(q, nil)
+		assert.True(t, ok)
+		rank := int(math.Ceil(q*1001)) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		assert.Equal(t, values[rank], v)
+	}
+	median, _ := bsi.Median(nil)
+	assert.Equal(t, float64(values[500]), median)
+}
+
+func TestHistogram(t *testing.T) {
+
+	bsi := setupNegative()
+	assert.Equal(t, []uint64{200}, bsi.Histogram(nil, nil))
+	assert.Equal(t, []uint64{0, 100, 50, 49, 0, 1, 0},
+		bsi.Histogram([]int64{-1000, 0, 50, 99, 99, 1 << 40}, nil))
+	assert.Equal(t, []uint64{1, 1, 1},
+		bsi.Histogram([]int64{-99, -50}, BitmapOf(0, 1, 50, 1000)))
+	assert.Equal(t, []uint64{200, 0},
+		bsi.Histogram([]int64{math.MaxInt64}, nil))
+	assert.Equal(t, []uint64{0, 200},
+		bsi.Histogram([]int64{math.MinInt64}, nil))
+	assert.Panics(t, func() { bsi.Histogram([]int64{1, 0}, nil) })
+}
+
+var arithmeticValues = []int64{0, 1, -1, 7, -8, 100, -100, 1 << 40, -1 << 40, math.MaxInt64, math.MinInt64}
+
+func TestSubtract(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	other := NewDefaultBSI()
+	for i, a := range arithmeticValues {
+		for j, b := range arithmeticValues {
+			bsi.SetValue(uint64(i*len(arithmeticValues)+j), a)
+			other.SetValue(uint64(i*len(arithmeticValues)+j), b)
+		}
+	}
+	// columns without a value in one of the BSIs count as zero
+	bsi.SetValue(1000, 5)
+	other.SetValue(1001, 5)
+	bsi.Subtract(other)
+	for i, a := range arithmeticValues {
+		for j, b := range arithmeticValues {
+			v, ok := bsi.GetValue(uint64(i*len(arithmeticValues) + j))
+			assert.True(t, ok)
+			assert.Equal(t, a-b, v, "%d - %d", a, b)
+		}
+	}
+	v, _ := bsi.GetValue(1000)
+	assert.Equal(t, int64(5), v)
+	v, _ = bsi.GetValue(1001)
+	assert.Equal(t, int64(-5), v)
+	_, ok := bsi.GetValue(1002)
+	assert.False(t, ok)
+}
+
+func TestNegate(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	for i, v := range arithmeticValues {
+		bsi.SetValue(uint64(i), v)
+	}
+	bsi.Negate()
+	assert.Equal(t, uint64(len(arithmeticValues)), bsi.GetCardinality())
+	for i, v := range arithmeticValues {
+		a, ok := bsi.GetValue(uint64(i))
+		assert.True(t, ok)
+		assert.Equal(t, -v, a)
+	}
+}
+
+func TestNegateWideColumns(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	bsi.SetValue(3, 0)
+	bsi.SetValue(1<<40, -5)
+	bsi.Negate()
+	assert.EqualValues(t, 2, bsi.GetCardinality())
+	for col, expected := range map[uint64]int64{3: 0, 1 << 40: 5} {
+		v, ok := bsi.GetValue(col)
+		assert.True(t, ok)
+		assert.Equal(t, expected, v)
+	}
+}
+
+func TestArithmeticRandom(t *testing.T) {
+
+	r := rand.New(rand.NewSource(3))
+	randomValue := func() int64 {
+		if r.Intn(4) == 0 {
+			return int64(r.Uint64())
+		}
+		return r.Int63n(2000) - 1000
+	}
+	// columns spread over several container keys
+	randomBSI := func() (*BSI, map[uint64]int64) {
+		bsi := NewDefaultBSI()
+		values := make(map[uint64]int64)
+		for i := r.Intn(50); i > 0; i-- {
+			col, value := uint64(r.Intn(4))<<40|uint64(r.Intn(1<<20)), randomValue()
+			bsi.SetValue(col, value)
+			values[col] = value
+		}
+		return bsi, values
+	}
+
+	for trial := 0; trial < 100; trial++ {
+		bsi, expected := randomBSI()
+		for step := 0; step < 5; step++ {
+			switch op := r.Intn(5); op {
+			case 0, 1:
+				other, values := randomBSI()
+				for col, v := range values {
+					if op == 0 {
+						expected[col] += v
+					} else {
+						expected[col] -= v
+					}
+				}
+				if op == 0 {
+					bsi.Add(other)
+				} else {
+					bsi.Subtract(other)
+				}
+			case 2:
+				for col, v := range expected {
+					expected[col] = -v
+				}
+				bsi.Negate()
+			case 3:
+				c := randomValue()
+				for col, v := range expected {
+					expected[col] = v * c
+				}
+				bsi.MultiplyByConstant(c)
+			case 4:
+				for col, v := range expected {
+					expected[col] = v + 1
+				}
+				bsi.IncrementAll()
+			}
+			require.EqualValues(t, len(expected), bsi.GetCardinality(), "trial %d step %d", trial, step)
+			for col, v := range expected {
+				a, ok := bsi.GetValue(col)
+				require.True(t, ok)
+				require.Equal(t, v, a, "trial %d step %d column %d", trial, step, col)
+			}
+		}
+	}
+}
+
+func TestMultiplyByConstant(t *testing.T) {
+
+	for _, c := range arithmeticValues {
+		bsi := NewDefaultBSI()
+		for i, v := range arithmeticValues {
+			bsi.SetValue(uint64(i), v)
+		}
+		bsi.MultiplyByConstant(c)
+		assert.Equal(t, uint64(len(arithmeticValues)), bsi.GetCardinality())
+		for i, v := range arithmeticValues {
+			a, ok := bsi.GetValue(uint64(i))
+			assert.True(t, ok)
+			assert.Equal(t, v*c, a, "%d * %d", v, c)
+		}
+	}
+
+	bsi := setupNegative()
+	bsi.MultiplyByConstant(-3)
+	for i := bsi.MinValue; i < bsi.MaxValue; i++ {
+		a, _ := bsi.GetValue(uint64(i - bsi.MinValue))
+		assert.Equal(t, -3*i, a)
+	}
+}
+
+func TestCompareBSI(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	other := NewDefaultBSI()
+	for i, a := range arithmeticValues {
+		for j, b := range arithmeticValues {
+			bsi.SetValue(uint64(i*len(arithmeticValues)+j), a)
+			other.SetValue(uint64(i*len(arithmeticValues)+j), b)
+		}
+	}
+	bsi.SetValue(1000, 5)
+	other.SetValue(1001, 5)
+
+	for _, op := range []Operation{LT, LE, EQ, GE, GT} {
+		expected := NewBitmap()
+		for i, a := range arithmeticValues {
+			for j, b := range arithmeticValues {
+				if (op == LT && a < b) || (op == LE && a <= b) || (op == EQ && a == b) ||
+					(op == GE && a >= b) || (op == GT && a > b) {
+					expected.Add(uint64(i*len(arithmeticValues) + j))
+				}
+			}
+		}
+		assert.True(t, expected.Equals(bsi.CompareBSI(op, other, nil)), "op %d", op)
+
+		foundSet := BitmapOf(0, 1, 2, 3, 1000, 1001)
+		expected.And(foundSet)
+		assert.True(t, expected.Equals(bsi.CompareBSI(op, other, foundSet)), "op %d", op)
+	}
+	assert.Panics(t, func() { bsi.CompareBSI(RANGE, other, nil) })
+
+	// BSIs of different widths
+	bsi = NewDefaultBSI()
+	bsi.SetValue(1, -3)
+	bsi.SetValue(2, 3)
+	bsi.SetValue(3, 3)
+	other = NewDefaultBSI()
+	other.SetValue(1, -1<<20)
+	other.SetValue(2, 1<<20)
+	other.SetValue(3, 3)
+	assert.Equal(t, []uint64{2}, bsi.CompareBSI(LT, other, nil).ToArray())
+	assert.Equal(t, []uint64{3}, bsi.CompareBSI(EQ, other, nil).ToArray())
+	assert.Equal(t, []uint64{1}, bsi.CompareBSI(GT, other, nil).ToArray())
+}
+
+func TestSumBy(t *testing.T) {
+
+	bsi := setupNegative()
+	groups := map[string]*Bitmap{
+		"negative": BitmapOf(0, 1, 99),
+		"mixed":    BitmapOf(50, 150, 199, 1000),
+		"missing":  BitmapOf(1000),
+	}
+	results := bsi.SumBy(0, groups, nil)
+	assert.Equal(t, map[string]GroupStats{
+		"negative": {Sum: -100 - 99 - 1, Count: 3, Min: -100, Max: -1},
+		"mixed":    {Sum: -50 + 50 + 99, Count: 3, Min: -50, Max: 99},
+		"missing":  {},
+	}, results)
+
+	results = bsi.SumBy(2, groups, BitmapOf(0, 150))
+	assert.Equal(t, GroupStats{Sum: -100, Count: 1, Min: -100, Max: -100}, results["negative"])
+	assert.Equal(t, GroupStats{Sum: 50, Count: 1, Min: 50, Max: 50}, results["mixed"])
+}
+
+func TestValueGroups(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	for col, value := range map[uint64]int64{1: -5, 2: 0, 3: 7, 4: -5, 5: 1 << 40, 6: 7, 7: -1 << 40, 8: 0} {
+		bsi.SetValue(col, value)
+	}
+	values, groups := bsi.valueGroups(bsi.GetExistenceBitmap())
+	results := make(map[int64]*Bitmap, len(values))
+	for i, value := range values {
+		results[value] = groups[i]
+	}
+	assert.Equal(t, map[int64]*Bitmap{
+		-5:       BitmapOf(1, 4),
+		0:        BitmapOf(2, 8),
+		7:        BitmapOf(3, 6),
+		1 << 40:  BitmapOf(5),
+		-1 << 40: BitmapOf(7),
+	}, results)
+}
+
+func TestSumByBSI(t *testing.T) {
+
+	bsi := NewDefaultBSI()
+	groupKeys := NewDefaultBSI()
+	expected := make(map[int64]GroupStats)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		value := r.Int63n(2000) - 1000
+		key := r.Int63n(10) - 3
+		bsi.SetValue(uint64(i), value)
+		if i%100 == 0 {
+			// columns without a key are ignored
+			continue
+		}
+		groupKeys.SetValue(uint64(i), key)
+		stats, ok := expected[key]
+		if !ok {
+			stats = GroupStats{Min: value, Max: value}
+		}
+		stats.Sum += value
+		stats.Count++
+		if value < stats.Min {
+			stats.Min = value
+		}
+		if value > stats.Max {
+			stats.Max = value
+		}
+		expected[key] = stats
+	}
+	groupKeys.SetValue(5000, 1)
+
+	for _, parallelism := range []int{0, 1, 3} {
+		assert.Equal(t, expected, bsi.SumByBSI(parallelism, groupKeys, nil))
+	}
+
+	foundSet := BitmapOf(1, 2, 5000)
+	results := bsi.SumByBSI(0, groupKeys, foundSet)
+	var count uint64
+	for _, stats := range results {
+		count += stats.Count
+	}
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+
+	bsi := setupNegative()
+	bsi.RunOptimize()
+	other := setup()
+
+	var buf bytes.Buffer
+	n, err := bsi.WriteTo(&buf)
+	require.Nil(t, err)
+	m, err := other.WriteTo(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n+m)
+	data := buf.Bytes()
+
+	check := func(t *testing.T, expected, actual *BSI) {
+		assert.Equal(t, expected.MinValue, actual.MinValue)
+		assert.Equal(t, expected.MaxValue, actual.MaxValue)
+		assert.Equal(t, expected.runOptimized, actual.runOptimized)
+		assert.Equal(t, expected.BitCount(), actual.BitCount())
+		assert.True(t, expected.GetExistenceBitmap().Equals(actual.GetExistenceBitmap()))
+		for i := expected.MinValue; i < expected.MaxValue; i++ {
+			a, ok := actual.GetValue(uint64(i - expected.MinValue))
+			e, _ := expected.GetValue(uint64(i - expected.MinValue))
+			assert.True(t, ok)
+			assert.Equal(t, e, a)
+		}
+	}
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		first, second := NewDefaultBSI(), NewDefaultBSI()
+		p, err := first.ReadFrom(&buf)
+		require.Nil(t, err)
+		assert.Equal(t, n, p)
+		p, err = second.ReadFrom(&buf)
+		require.Nil(t, err)
+		assert.Equal(t, m, p)
+		check(t, bsi, first)
+		check(t, other, second)
+	})
+
+	t.Run("FromBuffer", func(t *testing.T) {
+		first, second := NewDefaultBSI(), NewDefaultBSI()
+		p, err := first.FromBuffer(data)
+		require.Nil(t, err)
+		assert.Equal(t, n, p)
+		p, err = second.FromBuffer(data[p:])
+		require.Nil(t, err)
+		assert.Equal(t, m, p)
+		check(t, bsi, first)
+		check(t, other, second)
+
+		// the BSI can be modified without modifying the buffer
+		saved := append([]byte(nil), data...)
+		first.SetValue(0, 1<<40)
+		first.IncrementAll()
+		assert.Equal(t, saved, data)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, corrupt := range [][]byte{
+			nil,
+			data[:10],
+			data[:bsiHeaderSize+5],
+			append([]byte{'X'}, data[1:n]...),
+			append([]byte{'B', 'S', 'I', 2}, data[4:n]...),
+			append(append([]byte(nil), data[:5]...), append([]byte{64}, data[6:n]...)...),
+		} {
+			_, err := NewDefaultBSI().ReadFrom(bytes.NewReader(corrupt))
+			assert.Error(t, err)
+			_, err = NewDefaultBSI().FromBuffer(corrupt)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestSetValues(t *testing.T) {
+
+	r := rand.New(rand.NewSource(0))
+	for _, build := range []func() *BSI{NewDefaultBSI, func() *BSI { return NewBSI(1000, -1000) }} {
+		expected, bsi := build(), build()
+		for i := 0; i < 1000; i++ {
+			value := r.Int63n(100) - 50
+			expected.SetValue(uint64(i), value)
+			bsi.SetValue(uint64(i), value)
+		}
+
+		// unsorted column IDs with duplicates, overwriting some of the values
+		columnIDs := make([]uint64, 5000)
+		values := make([]int64, 5000)
+		for i := range columnIDs {
+			columnIDs[i] = uint64(r.Int63n(3000)) << uint(r.Intn(40))
+			values[i] = r.Int63n(1<<20) - 1<<19
+			expected.SetValue(columnIDs[i], values[i])
+		}
+		require.NoError(t, bsi.SetValues(columnIDs, values))
+
+		assert.True(t, expected.GetExistenceBitmap().Equals(bsi.GetExistenceBitmap()))
+		iter := expected.GetExistenceBitmap().Iterator()
+		for iter.HasNext() {
+			cID := uint64(iter.Next())
+			e, _ := expected.GetValue(cID)
+			a, ok := bsi.GetValue(cID)
+			assert.True(t, ok)
+			assert.Equal(t, e, a, "column %d", cID)
+		}
+	}
+}
+
+func TestSetValuesErrors(t *testing.T) {
+
+	bsi := setup()
+	assert.Error(t, bsi.SetValues([]uint64{1, 2}, []int64{1}))
+	// nothing is set on error
+	v, _ := bsi.GetValue(1)
+	assert.Equal(t, int64(1), v)
+	assert.Equal(t, uint64(100), bsi.GetCardinality())
+}
+
+func TestNewBSIFromSortedValues(t *testing.T) {
+
+	columnIDs := []uint64{1, 5, 6, 100000, 1 << 40, math.MaxUint64}
+	values := []int64{-3, 0, 1 << 40, math.MinInt64, math.MaxInt64, -1}
+	bsi, err := NewBSIFromSortedValues(columnIDs, values)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(columnIDs)), bsi.GetCardinality())
+	for i, cID := range columnIDs {
+		v, ok := bsi.GetValue(cID)
+		assert.True(t, ok)
+		assert.Equal(t, values[i], v)
+	}
+	// the BSI is automatically sized
+	bsi.SetValue(7, 1<<50)
+	v, _ := bsi.GetValue(7)
+	assert.Equal(t, int64(1<<50), v)
+
+	_, err = NewBSIFromSortedValues([]uint64{1, 1}, []int64{1, 2})
+	assert.Error(t, err)
+	_, err = NewBSIFromSortedValues([]uint64{2, 1}, []int64{1, 2})
+	assert.Error(t, err)
+	_, err = NewBSIFromSortedValues([]uint64{1}, nil)
+	assert.Error(t, err)
+}
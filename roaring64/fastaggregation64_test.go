@@ -37,3 +37,91 @@ func TestFastAggregationsAdvanced_run(t *testing.T) {
 	assert.True(t, FastOr(rb1, rb2, rb3).Equals(rb1))
 	assert.True(t, FastAnd(rb1, rb2, rb3).Equals(bigand))
 }
+
+func TestFastAggregationsAndAny(t *testing.T) {
+	base := NewBitmap()
+	rb1 := NewBitmap()
+	rb2 := NewBitmap()
+	rb3 := NewBitmap()
+	// only one filter has some values
+	from := uint64(4) << 32
+	for i := from; i < from+100; i += 2 {
+		rb1.Add(i)
+	}
+	// only base has values
+	from = uint64(7) << 32
+	for i := from; i < from+100; i += 2 {
+		base.Add(i)
+	}
+	// base and one of filters have same values
+	from = uint64(8) << 32
+	for i := from; i < from+100; i += 2 {
+		base.Add(i)
+		rb1.Add(i)
+	}
+	// small union
+	from = uint64(10) << 32
+	for i := from; i < from+1000; i += 10 {
+		base.Add(i)
+		base.Add(i + i%3)
+
+		rb1.Add(i)
+		rb1.Add(i + 1)
+
+		rb2.Add(i + 2)
+		rb2.Add(i + i%7)
+
+		rb3.Add(200 + i)
+	}
+	// run filters
+	for i := from; i < from+100; i++ {
+		rb1.Add(i)
+		rb2.Add(i + 333)
+		rb3.Add(i + 433)
+	}
+	// large union, over several 16-bit containers of the same 32-bit key
+	from = uint64(16) << 32
+	for i := from; i < from+200000; i += 3 {
+		base.Add(i)
+		base.Add(i + i%2 + 1)
+		rb2.Add(i)
+		rb3.Add(i + 1)
+	}
+
+	// some extra base values
+	from = uint64(17) << 32
+	for i := from; i < from+1000; i++ {
+		base.Add(i)
+	}
+
+	base.RunOptimize()
+	rb1.RunOptimize()
+	rb2.RunOptimize()
+	rb3.RunOptimize()
+
+	orFirst := base.Clone()
+	orFirst.And(FastOr(rb1, rb2, rb3))
+
+	fast := base.Clone()
+	fast.AndAny(rb1, rb2, rb3)
+	assert.True(t, fast.Equals(orFirst))
+
+	// copy-on-write bitmaps are not modified
+	base.SetCopyOnWrite(true)
+	cow := base.Clone()
+	cow.AndAny(rb1, rb2, rb3)
+	assert.True(t, cow.Equals(orFirst))
+	assert.False(t, base.Equals(orFirst))
+
+	single := base.Clone()
+	single.AndAny(rb2)
+	assert.True(t, single.Equals(And(base, rb2)))
+
+	none := base.Clone()
+	none.AndAny()
+	assert.True(t, none.Equals(base))
+
+	empty := base.Clone()
+	empty.AndAny(NewBitmap(), NewBitmap())
+	assert.True(t, empty.IsEmpty())
+}
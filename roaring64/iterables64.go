@@ -125,6 +125,10 @@ func newIntReverseIterator(a *Bitmap) *intReverseIterator {
 type ManyIntIterable64 interface {
 	// pass in a buffer to fill up with values, returns how many values were returned
 	NextMany([]uint64) int
+	// NextManyUntil fills buf up with values smaller than ceiling, returns how many values were returned
+	NextManyUntil(ceiling uint64, buf []uint64) int
+	// AdvanceIfNeeded advances as long as the next value is smaller than minval
+	AdvanceIfNeeded(minval uint64)
 }
 
 type manyIntIterator struct {
@@ -132,9 +136,14 @@ type manyIntIterator struct {
 	hs               uint64
 	iter             roaring.ManyIntIterable
 	highlowcontainer *roaringArray64
+
+	// consumed is the number of values of the current 32-bit bitmap which were
+	// returned or skipped by AdvanceIfNeeded, i.e., the position in that bitmap
+	consumed uint64
 }
 
 func (ii *manyIntIterator) init() {
+	ii.consumed = 0
 	if ii.highlowcontainer.size() > ii.pos {
 		ii.iter = ii.highlowcontainer.getContainerAtIndex(ii.pos).ManyIterator()
 		ii.hs = uint64(ii.highlowcontainer.getKeyAtIndex(ii.pos)) << 32
@@ -151,6 +160,7 @@ func (ii *manyIntIterator) NextMany(buf []uint64) int {
 		}
 		moreN := ii.iter.NextMany64(ii.hs, buf[n:])
 		n += moreN
+		ii.consumed += uint64(moreN)
 		if moreN == 0 {
 			ii.pos = ii.pos + 1
 			ii.init()
@@ -160,6 +170,70 @@ func (ii *manyIntIterator) NextMany(buf []uint64) int {
 	return n
 }
 
+// NextManyUntil fills buf up with values smaller than ceiling, returns how many values were returned.
+// The following values, starting at ceiling, are left to the next calls.
+func (ii *manyIntIterator) NextManyUntil(ceiling uint64, buf []uint64) int {
+	n := 0
+	for n < len(buf) {
+		if ii.iter == nil || ii.hs > ceiling {
+			break
+		}
+		dst := buf[n:]
+		if ii.hs>>32 == ceiling>>32 {
+			remaining := ii.remainingBelow(lowbits(ceiling))
+			if remaining == 0 {
+				break
+			}
+			if remaining < uint64(len(dst)) {
+				dst = dst[:remaining]
+			}
+		}
+		moreN := ii.iter.NextMany64(ii.hs, dst)
+		n += moreN
+		ii.consumed += uint64(moreN)
+		if moreN == 0 {
+			ii.pos = ii.pos + 1
+			ii.init()
+		}
+	}
+
+	return n
+}
+
+// remainingBelow returns the number of values of the current 32-bit bitmap
+// which are smaller than x and were not read yet.
+func (ii *manyIntIterator) remainingBelow(x uint32) uint64 {
+	if x == 0 {
+		return 0
+	}
+	below := ii.highlowcontainer.getContainerAtIndex(ii.pos).Rank(x - 1)
+	if below <= ii.consumed {
+		return 0
+	}
+	return below - ii.consumed
+}
+
+// AdvanceIfNeeded advances as long as the next value is smaller than minval
+func (ii *manyIntIterator) AdvanceIfNeeded(minval uint64) {
+	to := minval >> 32
+
+	for ii.iter != nil && (ii.hs>>32) < to {
+		ii.pos++
+		ii.init()
+	}
+
+	if ii.iter != nil && (ii.hs>>32) == to {
+		low := lowbits(minval)
+		ii.iter.AdvanceIfNeeded(low)
+		// the values below minval were skipped, whether they were returned or not
+		if low > 0 {
+			if skipped := ii.highlowcontainer.getContainerAtIndex(ii.pos).Rank(low - 1); skipped > ii.consumed {
+				ii.consumed = skipped
+			}
+		}
+	}
+}
+
 func newManyIntIterator(a *Bitmap) *manyIntIterator {
 	p := new(manyIntIterator)
 	p.pos = 0
@@ -180,3 +180,119 @@ func TestIteratorAdvance(t *testing.T) {
 		assert.EqualValues(t, 31, i.PeekNext())
 	})
 }
+
+func manyIteratorTestBitmap() *Bitmap {
+	bm := New()
+	bm.AddRange(10, 20)
+	bm.AddMany([]uint64{70000, 70003, 1<<32 - 1})
+	for i := uint64(1<<32 + 200000); i < 1<<32+210000; i += 2 {
+		bm.Add(i)
+	}
+	bm.AddRange(5<<32, 5<<32+100000)
+	bm.Add(math.MaxUint64)
+	bm.RunOptimize()
+	return bm
+}
+
+func TestManyIteratorAdvanceIfNeeded(t *testing.T) {
+	bm := manyIteratorTestBitmap()
+	values := bm.ToArray()
+
+	for _, minval := range []uint64{0, 15, 20, 70001, 1<<32 - 1, 1 << 32, 1<<32 + 200001, 1<<32 + 209999, 2 << 32, 5<<32 + 99999, 6 << 32, math.MaxUint64} {
+		it := bm.ManyIterator()
+		it.AdvanceIfNeeded(minval)
+		var got []uint64
+		buf := make([]uint64, 7)
+		for n := it.NextMany(buf); n != 0; n = it.NextMany(buf) {
+			got = append(got, buf[:n]...)
+		}
+
+		var expected []uint64
+		for _, v := range values {
+			if v >= minval {
+				expected = append(expected, v)
+			}
+		}
+		assert.Equal(t, expected, got, "minval %d", minval)
+	}
+}
+
+func TestManyIteratorNextManyUntil(t *testing.T) {
+	bm := manyIteratorTestBitmap()
+	values := bm.ToArray()
+	ceilings := []uint64{0, 10, 15, 70003, 1<<32 - 1, 1 << 32, 1<<32 + 200001, 1<<32 + 200064, 5 << 32, 5<<32 + 50000, 6 << 32, math.MaxUint64}
+
+	for _, bufSize := range []int{1, 3, 64, 100000} {
+		buf := make([]uint64, bufSize)
+		it := bm.ManyIterator()
+		read := 0
+		for _, ceiling := range ceilings {
+			var got []uint64
+			for n := it.NextManyUntil(ceiling, buf); n != 0; n = it.NextManyUntil(ceiling, buf) {
+				got = append(got, buf[:n]...)
+			}
+
+			var expected []uint64
+			for read < len(values) && values[read] < ceiling {
+				expected = append(expected, values[read])
+				read++
+			}
+			assert.Equal(t, expected, got, "ceiling %d, buffer of %d", ceiling, bufSize)
+		}
+
+		// the remaining value is read without ceiling
+		assert.Equal(t, 1, it.NextMany(buf))
+		assert.Equal(t, uint64(math.MaxUint64), buf[0])
+		assert.Equal(t, 0, it.NextMany(buf))
+	}
+}
+
+func TestManyIteratorAdvanceAndNextManyUntil(t *testing.T) {
+	bm := manyIteratorTestBitmap()
+	buf := make([]uint64, 10)
+
+	it := bm.ManyIterator()
+	assert.Equal(t, 2, it.NextManyUntil(12, buf))
+	assert.Equal(t, []uint64{10, 11}, buf[:2])
+
+	it.AdvanceIfNeeded(15)
+	assert.Equal(t, 3, it.NextManyUntil(18, buf))
+	assert.Equal(t, []uint64{15, 16, 17}, buf[:3])
+
+	// advancing backwards does nothing
+	it.AdvanceIfNeeded(5)
+	assert.Equal(t, 2, it.NextManyUntil(70000, buf))
+	assert.Equal(t, []uint64{18, 19}, buf[:2])
+
+	it.AdvanceIfNeeded(70001)
+	assert.Equal(t, 1, it.NextManyUntil(1<<32-1, buf))
+	assert.Equal(t, uint64(70003), buf[0])
+
+	it.AdvanceIfNeeded(1<<32 + 209990)
+	assert.Equal(t, 7, it.NextManyUntil(5<<32+2, buf))
+	assert.Equal(t, []uint64{1<<32 + 209990, 1<<32 + 209992, 1<<32 + 209994, 1<<32 + 209996, 1<<32 + 209998, 5 << 32, 5<<32 + 1}, buf[:7])
+	assert.Equal(t, 0, it.NextManyUntil(5<<32+2, buf))
+
+	it.AdvanceIfNeeded(5<<32 + 99998)
+	assert.Equal(t, 3, it.NextMany(buf))
+	assert.Equal(t, []uint64{5<<32 + 99998, 5<<32 + 99999, math.MaxUint64}, buf[:3])
+}
+
+func TestManyIteratorAdvanceNextManyAndNextManyUntil(t *testing.T) {
+	buf := make([]uint64, 10)
+	it := BitmapOf(10, 20, 30, 40, 50).ManyIterator()
+	it.AdvanceIfNeeded(15)
+	assert.Equal(t, 1, it.NextMany(buf[:1]))
+	assert.Equal(t, uint64(20), buf[0])
+	assert.Equal(t, 1, it.NextManyUntil(35, buf))
+	assert.Equal(t, uint64(30), buf[0])
+	assert.Equal(t, 2, it.NextMany(buf))
+	assert.Equal(t, []uint64{40, 50}, buf[:2])
+
+	// values returned before advancing are not counted twice
+	it = BitmapOf(10, 20, 30, 40, 50).ManyIterator()
+	assert.Equal(t, 3, it.NextMany(buf[:3]))
+	it.AdvanceIfNeeded(25)
+	assert.Equal(t, 1, it.NextManyUntil(45, buf))
+	assert.Equal(t, uint64(40), buf[0])
+}
@@ -0,0 +1,147 @@
+package roaring64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// javaGoldenBSIs gives the content of the golden files of the Java
+// Roaring64NavigableMapSliceIndex format in testdata.
+//
+// testdata/GoldenFiles.java writes the same BSIs with Java
+// (Roaring64NavigableMapSliceIndex.serialize), as described in
+// testdata/README.md. Until the files are regenerated with it, they are
+// those written by javaEncode, which follows the Java format from the values
+// alone.
+var javaGoldenBSIs = map[string]func() *BSI{
+	"java_bsi64_empty.bin": NewDefaultBSI,
+	"java_bsi64.bin": func() *BSI {
+		bsi := NewBSI(999, 0)
+		for i := 0; i < 1000; i++ {
+			bsi.SetValue(uint64(3*i), int64(i*i%1000))
+		}
+		bsi.RunOptimize()
+		return bsi
+	},
+	"java_bsi64_wide.bin": func() *BSI {
+		bsi := NewBSI(1<<62, 5)
+		for i := 0; i < 100; i++ {
+			bsi.SetValue(uint64(i)<<30, 1<<62-int64(i))
+			bsi.SetValue(1<<63|uint64(i), int64(i)<<33)
+		}
+		bsi.SetValue(7, 5)
+		return bsi
+	},
+}
+
+// javaEncodeNavigableMap writes bm as described by Roaring64NavigableMap.serialize
+// of the Java library in its legacy mode, independently of writeJavaNavigableMap
+// and of the 32-bit serialization.
+func javaEncodeNavigableMap(t *testing.T, buf *bytes.Buffer, bm *Bitmap, runs bool) {
+	keys, lows := splitValues(bm)
+	require.NoError(t, binary.Write(buf, binary.BigEndian, false))
+	require.NoError(t, binary.Write(buf, binary.BigEndian, int32(len(keys))))
+	for i, key := range keys {
+		require.NoError(t, binary.Write(buf, binary.BigEndian, key))
+		buf.Write(specEncode(t, lows[i], runs))
+	}
+}
+
+// javaEncode writes bsi as described by Roaring64NavigableMapSliceIndex.serialize
+// of the Java library, independently of WriteToJava.
+func javaEncode(t *testing.T, bsi *BSI) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, bsi.MinValue))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, bsi.MaxValue))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, bsi.runOptimized))
+	javaEncodeNavigableMap(t, &buf, bsi.GetExistenceBitmap(), bsi.runOptimized)
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, int32(bsi.BitCount())))
+	for _, bm := range bsi.bA {
+		javaEncodeNavigableMap(t, &buf, bm, bsi.runOptimized)
+	}
+	return buf.Bytes()
+}
+
+func TestJavaFormatGoldenFiles(t *testing.T) {
+	for name, build := range javaGoldenBSIs {
+		expected := build()
+		data, err := ioutil.ReadFile("testdata/" + name)
+		require.NoError(t, err, name)
+
+		assert.Equal(t, javaEncode(t, expected), data, name)
+
+		var buf bytes.Buffer
+		n, err := expected.WriteToJava(&buf)
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.Equal(t, data, buf.Bytes(), name)
+
+		bsi := NewDefaultBSI()
+		n, err = bsi.ReadFromJava(bytes.NewReader(data))
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.Equal(t, expected.MinValue, bsi.MinValue, name)
+		assert.Equal(t, expected.MaxValue, bsi.MaxValue, name)
+		assert.Equal(t, expected.runOptimized, bsi.runOptimized, name)
+		assert.Equal(t, expected.BitCount(), bsi.BitCount(), name)
+		assert.True(t, expected.GetExistenceBitmap().Equals(bsi.GetExistenceBitmap()), name)
+		iter := expected.GetExistenceBitmap().Iterator()
+		for iter.HasNext() {
+			cID := iter.Next()
+			e, _ := expected.GetValue(cID)
+			a, ok := bsi.GetValue(cID)
+			assert.True(t, ok, name)
+			assert.Equal(t, e, a, name)
+		}
+	}
+}
+
+func TestJavaNavigableMapSignedLongs(t *testing.T) {
+	// Java maps with signed longs sort the negative keys first, and may hold empty bitmaps
+	var buf bytes.Buffer
+	buf.Write([]byte{1, 0, 0, 0, 3})
+	for _, c := range []struct {
+		key    uint32
+		values []uint32
+	}{{0xFFFFFFFF, []uint32{1, 2}}, {5, nil}, {7, []uint32{3}}} {
+		require.NoError(t, binary.Write(&buf, binary.BigEndian, c.key))
+		inner, err := roaring.BitmapOf(c.values...).ToBytes()
+		require.NoError(t, err)
+		buf.Write(inner)
+	}
+
+	bm, n, err := readJavaNavigableMap(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.EqualValues(t, buf.Len(), n)
+	assert.Equal(t, []uint64{7<<32 | 3, 0xFFFFFFFF<<32 | 1, 0xFFFFFFFF<<32 | 2}, bm.ToArray())
+	assert.NoError(t, bm.Validate())
+}
+
+func TestJavaFormatErrors(t *testing.T) {
+	bsi := NewDefaultBSI()
+	bsi.SetValue(1, -1)
+	_, err := bsi.WriteToJava(&bytes.Buffer{})
+	assert.Error(t, err)
+
+	data := javaEncode(t, javaGoldenBSIs["java_bsi64.bin"]())
+	for _, n := range []int{0, 8, 17, 20, 30, len(data) - 1} {
+		_, err = NewDefaultBSI().ReadFromJava(bytes.NewReader(data[:n]))
+		assert.Error(t, err, "%d bytes", n)
+	}
+
+	// duplicate keys
+	inner, err := roaring.BitmapOf(1).ToBytes()
+	require.NoError(t, err)
+	data = []byte{0, 0, 0, 0, 2, 0, 0, 0, 1}
+	data = append(data, inner...)
+	data = append(data, 0, 0, 0, 1)
+	data = append(data, inner...)
+	_, _, err = readJavaNavigableMap(bytes.NewReader(data))
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+package roaring64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// portableGoldenBitmaps gives the content of the golden files of the
+// portable 64-bit format in testdata.
+//
+// testdata/GoldenFiles.java writes the same bitmaps with Java
+// (Roaring64NavigableMap in portable mode), as described in
+// testdata/README.md. Until the files are regenerated with it, they are
+// those written by portableEncode, which follows the specification from the
+// values alone, without the serialization code of this module.
+var portableGoldenBitmaps = map[string]func() *Bitmap{
+	"portable_empty.bin": NewBitmap,
+	"portable_32bitvals.bin": func() *Bitmap {
+		rb := NewBitmap()
+		for i := uint64(0); i < 1000; i++ {
+			rb.Add(3 * i)
+		}
+		return rb
+	},
+	"portable_spreadvals.bin": func() *Bitmap {
+		rb := NewBitmap()
+		for i := uint64(0); i < 10; i++ {
+			for j := uint64(0); j < 1000; j++ {
+				rb.Add(i<<32 | j*7)
+			}
+		}
+		rb.AddRange(1<<40, 1<<40+100000)
+		rb.RunOptimize()
+		return rb
+	},
+	"portable_highvals.bin": func() *Bitmap {
+		rb := NewBitmap()
+		for i := uint64(0); i < 1000; i++ {
+			rb.Add(math.MaxUint64 - i)
+			rb.Add(math.MaxUint64 - 1<<32 - 5*i)
+		}
+		for i := uint64(0); i < 10000; i++ {
+			rb.Add(1<<63 + 3*i)
+		}
+		return rb
+	},
+}
+
+// portableGoldenRunOptimized gives the golden files whose bitmaps are run
+// optimized, as the inner bitmaps are then written with run containers.
+var portableGoldenRunOptimized = map[string]bool{
+	"portable_spreadvals.bin": true,
+}
+
+// specEncode writes the sorted values as a 32-bit bitmap in the format of
+// the RoaringFormatSpec, from the values alone rather than from the
+// containers of the roaring package. When runs is true, the values of a key
+// are written as a run container if that is not larger than an array or a
+// bitmap container, as run optimization does.
+func specEncode(t *testing.T, values []uint32, runs bool) []byte {
+	const (
+		serialCookieNoRunContainer = 12346
+		serialCookie               = 12347
+		noOffsetThreshold          = 4
+		arrayMaxSize               = 4096
+		bitmapBytes                = 8192
+	)
+	var keys []uint16
+	var lows [][]uint16
+	for _, v := range values {
+		if len(keys) == 0 || keys[len(keys)-1] != uint16(v>>16) {
+			keys = append(keys, uint16(v>>16))
+			lows = append(lows, nil)
+		}
+		lows[len(lows)-1] = append(lows[len(lows)-1], uint16(v))
+	}
+
+	// the payload of each container and whether it holds runs
+	payloads := make([][]byte, len(keys))
+	isRun := make([]bool, len(keys))
+	hasRuns := false
+	for i, low := range lows {
+		var intervals []uint16 // start and length-1 of every run
+		for j, x := range low {
+			if j > 0 && low[j-1]+1 == x {
+				intervals[len(intervals)-1]++
+			} else {
+				intervals = append(intervals, x, 0)
+			}
+		}
+		var payload bytes.Buffer
+		nruns := len(intervals) / 2
+		switch {
+		case runs && 2+4*nruns <= bitmapBytes && 2+4*nruns <= 2*len(low):
+			isRun[i], hasRuns = true, true
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, uint16(nruns)))
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, intervals))
+		case len(low) <= arrayMaxSize:
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, low))
+		default:
+			words := make([]uint64, 1<<10)
+			for _, x := range low {
+				words[x/64] |= 1 << (x % 64)
+			}
+			require.NoError(t, binary.Write(&payload, binary.LittleEndian, words))
+		}
+		payloads[i] = payload.Bytes()
+	}
+
+	var buf bytes.Buffer
+	if hasRuns {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(serialCookie|(len(keys)-1)<<16)))
+		runBitmap := make([]byte, (len(keys)+7)/8)
+		for i := range keys {
+			if isRun[i] {
+				runBitmap[i/8] |= 1 << (i % 8)
+			}
+		}
+		buf.Write(runBitmap)
+	} else {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(serialCookieNoRunContainer)))
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(len(keys))))
+	}
+	for i, key := range keys {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, []uint16{key, uint16(len(lows[i]) - 1)}))
+	}
+	if !hasRuns || len(keys) >= noOffsetThreshold {
+		offset := buf.Len() + 4*len(keys)
+		for _, payload := range payloads {
+			require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(offset)))
+			offset += len(payload)
+		}
+	}
+	for _, payload := range payloads {
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+// splitValues groups the sorted values of rb by their 32-bit high key.
+func splitValues(rb *Bitmap) (keys []uint32, lows [][]uint32) {
+	for _, v := range rb.ToArray() {
+		if len(keys) == 0 || keys[len(keys)-1] != uint32(v>>32) {
+			keys = append(keys, uint32(v>>32))
+			lows = append(lows, nil)
+		}
+		lows[len(lows)-1] = append(lows[len(lows)-1], uint32(v))
+	}
+	return keys, lows
+}
+
+// portableEncode writes rb as described by the portable 64-bit format of the
+// RoaringFormatSpec, independently of WriteTo and of the 32-bit serialization.
+func portableEncode(t *testing.T, rb *Bitmap, runs bool) []byte {
+	var buf bytes.Buffer
+	keys, lows := splitValues(rb)
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint64(len(keys))))
+	for i, key := range keys {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, key))
+		buf.Write(specEncode(t, lows[i], runs))
+	}
+	return buf.Bytes()
+}
+
+func TestPortableFormatGoldenFiles(t *testing.T) {
+	for name, build := range portableGoldenBitmaps {
+		expected := build()
+		data, err := ioutil.ReadFile("testdata/" + name)
+		require.NoError(t, err, name)
+
+		assert.Equal(t, portableEncode(t, expected, portableGoldenRunOptimized[name]), data, name)
+
+		written, err := expected.ToBytes()
+		require.NoError(t, err)
+		assert.Equal(t, data, written, name)
+
+		rb := NewBitmap()
+		n, err := rb.ReadFrom(bytes.NewReader(data))
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.True(t, expected.Equals(rb), name)
+		assert.NoError(t, rb.Validate(), name)
+
+		rb = NewBitmap()
+		n, err = rb.FromBuffer(data)
+		require.NoError(t, err, name)
+		assert.EqualValues(t, len(data), n, name)
+		assert.True(t, expected.Equals(rb), name)
+		assert.EqualValues(t, len(data), expected.GetSerializedSizeInBytes(), name)
+	}
+}
+
+func TestPortableFormatLayout(t *testing.T) {
+	// {1, 2<<32 | 3} laid out by hand: the number of keys, then every key
+	// followed by a 32-bit bitmap with a single array container
+	expected := []byte{
+		2, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0x3A, 0x30, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 16, 0, 0, 0, 1, 0,
+		2, 0, 0, 0, 0x3A, 0x30, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 16, 0, 0, 0, 3, 0,
+	}
+
+	data, err := BitmapOf(1, 2<<32|3).ToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+}
+
+func TestPortableFormatEmptyBuckets(t *testing.T) {
+	// Java may serialize buckets with an empty bitmap, they are dropped
+	empty, err := roaring.NewBitmap().ToBytes()
+	require.NoError(t, err)
+	inner, err := roaring.BitmapOf(7).ToBytes()
+	require.NoError(t, err)
+	data := []byte{3, 0, 0, 0, 0, 0, 0, 0}
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, empty...)
+	data = append(data, 5, 0, 0, 0)
+	data = append(data, inner...)
+	data = append(data, 9, 0, 0, 0)
+	data = append(data, empty...)
+
+	rb := NewBitmap()
+	n, err := rb.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, []uint64{5<<32 | 7}, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+}
+
+func TestPortableFormatShortReads(t *testing.T) {
+	expected := portableGoldenBitmaps["portable_spreadvals.bin"]()
+	data, err := expected.ToBytes()
+	require.NoError(t, err)
+
+	// a reader returning one byte at a time, as network streams may do
+	rb := NewBitmap()
+	_, err = rb.ReadFrom(&oneByteReader{data})
+	require.NoError(t, err)
+	assert.True(t, expected.Equals(rb))
+
+	for _, n := range []int{0, 4, 8, 10, len(data) - 1} {
+		_, err = NewBitmap().ReadFrom(bytes.NewReader(data[:n]))
+		assert.Error(t, err, "%d bytes", n)
+		_, err = NewBitmap().FromBuffer(data[:n])
+		assert.Error(t, err, "%d bytes", n)
+	}
+}
+
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 
 	"github.com/RoaringBitmap/roaring"
@@ -43,7 +44,16 @@ func (rb *Bitmap) ToBytes() ([]byte, error) {
 	return buf.Bytes(), err
 }
 
-// WriteTo writes a serialized version of this bitmap to stream.
+// WriteTo writes a serialized version of this bitmap to stream, in the
+// portable 64-bit format of the RoaringFormatSpec, as written by
+// roaring64_bitmap_portable_serialize in CRoaring and by
+// Roaring64NavigableMap.serializePortable in Java:
+//
+//   - the number of 32-bit buckets, as a little-endian uint64,
+//   - for each bucket, in increasing order of the 32 most significant bits
+//     of its values, these bits as a little-endian uint32 followed by the
+//     32 least significant bits of the values as a 32-bit bitmap in the
+//     RoaringFormatSpec format.
 func (rb *Bitmap) WriteTo(stream io.Writer) (int64, error) {
 
 	var n int64
@@ -74,53 +84,39 @@ func (rb *Bitmap) WriteTo(stream io.Writer) (int64, error) {
 	return n, nil
 }
 
-// ReadFrom reads a serialized version of this bitmap from stream.
-// The format is compatible with other RoaringBitmap
-// implementations (Java, C) and is documented here:
-// https://github.com/RoaringBitmap/RoaringFormatSpec
+// ReadFrom reads a serialized version of this bitmap from stream, in the
+// portable 64-bit format written by WriteTo, which is compatible with
+// CRoaring (roaring64_bitmap_portable_deserialize_safe) and Java
+// (Roaring64NavigableMap.deserializePortable). Buckets holding an empty
+// bitmap are allowed in the input but are not kept.
 func (rb *Bitmap) ReadFrom(stream io.Reader) (p int64, err error) {
+	return rb.readFromWithOptions(stream, roaring.DeserializationOptions{})
+}
 
-	// TODO: Add buffer interning as in base roaring package.
+// FromBuffer creates a bitmap from its serialized version stored in buffer,
+// in the format written by WriteTo.
+//
+// The inner 32-bit bitmaps are created with roaring.Bitmap.FromBuffer: their
+// containers refer to buf as much as possible instead of copying it, and
+// they are copied when they are modified. The same precautions apply: buf
+// must not be modified, and CloneCopyOnWriteContainers must be called on
+// the bitmap and on the bitmaps derived from it before buf becomes
+// unavailable.
+func (rb *Bitmap) FromBuffer(buf []byte) (p int64, err error) {
+	return rb.fromBufferWithOptions(buf, roaring.DeserializationOptions{})
+}
 
-	sizeBuf := make([]byte, 8)
-	var n int
-	n, err = stream.Read(sizeBuf)
-	if n == 0 || err != nil {
-		return int64(n), fmt.Errorf("error in bitmap.readFrom: could not read number of containers: %s", err)
-	}
-	p += int64(n)
-	size := binary.LittleEndian.Uint64(sizeBuf)
-	rb.highlowcontainer = roaringArray64{}
-	rb.highlowcontainer.keys = make([]uint32, size)
-	rb.highlowcontainer.containers = make([]*roaring.Bitmap, size)
-	rb.highlowcontainer.needCopyOnWrite = make([]bool, size)
-	keyBuf := make([]byte, 4)
-	for i := uint64(0); i < size; i++ {
-		n, err = stream.Read(keyBuf)
-		if n == 0 || err != nil {
-			return int64(n), fmt.Errorf("error in bitmap.readFrom: could not read key #%d: %s", i, err)
-		}
-		p += int64(n)
-		rb.highlowcontainer.keys[i] = binary.LittleEndian.Uint32(keyBuf)
-		rb.highlowcontainer.containers[i] = roaring.NewBitmap()
-		n, err := rb.highlowcontainer.containers[i].ReadFrom(stream)
-		if n == 0 || err != nil {
-			return int64(n), fmt.Errorf("Could not deserialize bitmap for key #%d: %s", i, err)
-		}
-		p += int64(n)
+// GetSerializedSizeInBytes computes the serialized size in bytes
+// of the Bitmap. It should correspond to the number
+// of bytes written when invoking WriteTo.
+func (rb *Bitmap) GetSerializedSizeInBytes() uint64 {
+	size := uint64(8)
+	for _, c := range rb.highlowcontainer.containers {
+		size += 4 + c.GetSerializedSizeInBytes()
 	}
-
-	return p, nil
+	return size
 }
 
-// FromBuffer creates a bitmap from its serialized version stored in buffer
-// func (rb *Bitmap) FromBuffer(data []byte) (p int64, err error) {
-//
-//	// TODO: Add buffer interning as in base roaring package.
-//	buf := bytes.NewBuffer(data)
-//	return rb.ReadFrom(buf)
-// }
-
 // MarshalBinary implements the encoding.BinaryMarshaler interface for the bitmap
 // (same as ToBytes)
 func (rb *Bitmap) MarshalBinary() ([]byte, error) {
@@ -134,6 +130,192 @@ func (rb *Bitmap) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// ReadFromWithOptions reads a serialized version of this bitmap from stream
+// like ReadFrom, within the limits given by opts. The limits apply to the
+// bitmap as a whole: MaxContainers bounds the total number of 16-bit
+// containers. A *roaring.DeserializationLimitError is returned when the
+// input exceeds a limit. The bitmap is left empty when an error is returned.
+func (rb *Bitmap) ReadFromWithOptions(stream io.Reader, opts roaring.DeserializationOptions) (p int64, err error) {
+	p, err = rb.readFromWithOptions(stream, opts)
+	if err == nil && opts.Validate {
+		err = rb.Validate()
+	}
+	if err != nil {
+		rb.Clear()
+	}
+	return
+}
+
+// FromBufferWithOptions creates a bitmap from its serialized version stored
+// in buffer like FromBuffer, within the limits given by opts (see
+// ReadFromWithOptions).
+func (rb *Bitmap) FromBufferWithOptions(buf []byte, opts roaring.DeserializationOptions) (p int64, err error) {
+	p, err = rb.fromBufferWithOptions(buf, opts)
+	if err == nil && opts.Validate {
+		err = rb.Validate()
+	}
+	if err != nil {
+		rb.Clear()
+	}
+	return
+}
+
+func (rb *Bitmap) readFromWithOptions(stream io.Reader, opts roaring.DeserializationOptions) (int64, error) {
+	var buf []byte
+	return rb.deserialize(
+		func(_ int64, n int) ([]byte, error) {
+			if cap(buf) < n {
+				buf = make([]byte, n)
+			}
+			_, err := io.ReadFull(stream, buf[:n])
+			return buf[:n], err
+		},
+		func(bm *roaring.Bitmap, _ int64, opts roaring.DeserializationOptions) (int64, error) {
+			return bm.ReadFromWithOptions(stream, opts)
+		},
+		opts)
+}
+
+func (rb *Bitmap) fromBufferWithOptions(buf []byte, opts roaring.DeserializationOptions) (int64, error) {
+	return rb.deserialize(
+		func(p int64, n int) ([]byte, error) {
+			if int64(len(buf))-p < int64(n) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return buf[p : p+int64(n)], nil
+		},
+		func(bm *roaring.Bitmap, p int64, opts roaring.DeserializationOptions) (int64, error) {
+			return bm.FromBufferWithOptions(buf[p:], opts)
+		},
+		opts)
+}
+
+// deserialize reads a bitmap in the format written by WriteTo, using next to
+// get the n bytes at offset p of the input and readBitmap to read the 32-bit
+// bitmap at offset p.
+func (rb *Bitmap) deserialize(
+	next func(p int64, n int) ([]byte, error),
+	readBitmap func(bm *roaring.Bitmap, p int64, opts roaring.DeserializationOptions) (int64, error),
+	opts roaring.DeserializationOptions,
+) (p int64, err error) {
+	sizeBuf, err := next(p, 8)
+	if err != nil {
+		return p, fmt.Errorf("error in bitmap.readFrom: could not read number of containers: %s", err)
+	}
+	p += 8
+	size := binary.LittleEndian.Uint64(sizeBuf)
+	// every 32-bit key is followed by a non-empty bitmap, which has at least one container
+	if opts.MaxContainers > 0 && size > opts.MaxContainers {
+		return p, &roaring.DeserializationLimitError{Limit: "MaxContainers", Value: size, Max: opts.MaxContainers}
+	}
+	// every key takes 4 bytes; a size that large cannot be read anyway, so
+	// the required number of bytes saturates instead of overflowing
+	required := uint64(math.MaxUint64)
+	if size <= (math.MaxUint64-uint64(p))/4 {
+		required = uint64(p) + 4*size
+	}
+	if opts.MaxBytes > 0 && required > opts.MaxBytes {
+		return p, &roaring.DeserializationLimitError{Limit: "MaxBytes", Value: required, Max: opts.MaxBytes}
+	}
+
+	// the size is not trusted to allocate memory upfront
+	capacity := size
+	if capacity > 1<<16 {
+		capacity = 1 << 16
+	}
+	ra := &rb.highlowcontainer
+	*ra = roaringArray64{
+		keys:            make([]uint32, 0, capacity),
+		containers:      make([]*roaring.Bitmap, 0, capacity),
+		needCopyOnWrite: make([]bool, 0, capacity),
+	}
+	var containers, card uint64
+	for i := uint64(0); i < size; i++ {
+		keyBuf, err := next(p, 4)
+		if err != nil {
+			return p, fmt.Errorf("error in bitmap.readFrom: could not read key #%d: %s", i, err)
+		}
+		p += 4
+		key := binary.LittleEndian.Uint32(keyBuf)
+
+		// the inner bitmap gets what is left of the limits
+		inner := roaring.DeserializationOptions{Validate: opts.Validate}
+		if inner.MaxContainers, err = remainingLimit("MaxContainers", opts.MaxContainers, containers); err != nil {
+			return p, err
+		}
+		if inner.MaxBytes, err = remainingLimit("MaxBytes", opts.MaxBytes, uint64(p)); err != nil {
+			return p, err
+		}
+		if inner.MaxCardinality, err = remainingLimit("MaxCardinality", opts.MaxCardinality, card); err != nil {
+			return p, err
+		}
+
+		bm := roaring.NewBitmap()
+		n, err := readBitmap(bm, p, inner)
+		if err != nil {
+			if e, ok := err.(*roaring.DeserializationLimitError); ok {
+				// report the limits of the whole bitmap
+				switch e.Limit {
+				case "MaxContainers":
+					e.Value, e.Max = e.Value+containers, opts.MaxContainers
+				case "MaxBytes":
+					e.Value, e.Max = e.Value+uint64(p), opts.MaxBytes
+				case "MaxCardinality":
+					e.Value, e.Max = e.Value+card, opts.MaxCardinality
+				}
+				return p + n, err
+			}
+			return p + n, fmt.Errorf("error in bitmap.readFrom: could not deserialize bitmap for key #%d: %s", i, err)
+		}
+		p += n
+		if bm.IsEmpty() {
+			continue
+		}
+		containers += uint64(bm.Stats().Containers)
+		card += bm.GetCardinality()
+		ra.keys = append(ra.keys, key)
+		ra.containers = append(ra.containers, bm)
+		ra.needCopyOnWrite = append(ra.needCopyOnWrite, false)
+	}
+
+	return p, nil
+}
+
+// remainingLimit returns what is left of a limit of DeserializationOptions
+// once used has been consumed, 0 meaning no limit.
+func remainingLimit(name string, max, used uint64) (uint64, error) {
+	if max == 0 {
+		return 0, nil
+	}
+	if used >= max {
+		return 0, &roaring.DeserializationLimitError{Limit: name, Value: used + 1, Max: max}
+	}
+	return max - used, nil
+}
+
+// Validate checks the internal invariants of the bitmap: keys are sorted
+// and unique, and the bitmaps of the keys are non-empty and valid (see
+// roaring.Bitmap.Validate).
+func (rb *Bitmap) Validate() error {
+	ra := &rb.highlowcontainer
+	if len(ra.keys) != len(ra.containers) || len(ra.keys) != len(ra.needCopyOnWrite) {
+		return fmt.Errorf("invalid bitmap: %d keys, %d containers and %d copy-on-write flags",
+			len(ra.keys), len(ra.containers), len(ra.needCopyOnWrite))
+	}
+	for i, c := range ra.containers {
+		if i > 0 && ra.keys[i-1] >= ra.keys[i] {
+			return fmt.Errorf("invalid bitmap: keys are not strictly increasing at index %d", i)
+		}
+		if c == nil || c.IsEmpty() {
+			return fmt.Errorf("invalid bitmap: empty bitmap for key %d", ra.keys[i])
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("bitmap for key %d: %s", ra.keys[i], err)
+		}
+	}
+	return nil
+}
+
 // RunOptimize attempts to further compress the runs of consecutive values found in the bitmap
 func (rb *Bitmap) RunOptimize() {
 	rb.highlowcontainer.runOptimize()
@@ -212,6 +394,27 @@ func (rb *Bitmap) String() string {
 	return buffer.String()
 }
 
+// Iterate iterates over the bitmap, calling the given callback with each value in the bitmap.  If the callback returns
+// false, the iteration is halted.
+// The iteration results are undefined if the bitmap is modified (e.g., with Add or Remove).
+// There is no guarantee as to what order the values will be iterated
+func (rb *Bitmap) Iterate(cb func(x uint64) bool) {
+	for i := 0; i < rb.highlowcontainer.size(); i++ {
+		hs := uint64(rb.highlowcontainer.getKeyAtIndex(i)) << 32
+		c := rb.highlowcontainer.getContainerAtIndex(i)
+
+		shouldContinue := true
+		c.Iterate(func(x uint32) bool {
+			shouldContinue = cb(uint64(x) | hs)
+			return shouldContinue
+		})
+
+		if !shouldContinue {
+			break
+		}
+	}
+}
+
 // Iterator creates a new IntPeekable to iterate over the integers contained in the bitmap, in sorted order;
 // the iterator becomes invalid if the bitmap is modified (e.g., with Add or Remove).
 func (rb *Bitmap) Iterator() IntPeekable64 {
@@ -248,6 +451,99 @@ func (rb *Bitmap) Maximum() uint64 {
 	return uint64(rb.highlowcontainer.containers[lastindex].Maximum()) | (uint64(rb.highlowcontainer.keys[lastindex]) << 32)
 }
 
+// NextValue returns the smallest value in the bitmap that is greater than
+// or equal to x. The boolean is false if there is no such value.
+func (rb *Bitmap) NextValue(x uint64) (uint64, bool) {
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(highbits(x))
+	if i >= 0 {
+		if v := ra.containers[i].NextValue(lowbits(x)); v >= 0 {
+			return uint64(ra.keys[i])<<32 | uint64(v), true
+		}
+		i++
+	} else {
+		i = -i - 1
+	}
+	if i < len(ra.keys) {
+		return uint64(ra.keys[i])<<32 | uint64(ra.containers[i].Minimum()), true
+	}
+	return 0, false
+}
+
+// PreviousValue returns the largest value in the bitmap that is smaller than
+// or equal to x. The boolean is false if there is no such value.
+func (rb *Bitmap) PreviousValue(x uint64) (uint64, bool) {
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(highbits(x))
+	if i >= 0 {
+		if v := ra.containers[i].PreviousValue(lowbits(x)); v >= 0 {
+			return uint64(ra.keys[i])<<32 | uint64(v), true
+		}
+	} else {
+		i = -i - 1
+	}
+	if i > 0 {
+		return uint64(ra.keys[i-1])<<32 | uint64(ra.containers[i-1].Maximum()), true
+	}
+	return 0, false
+}
+
+// NextAbsentValue returns the smallest value that is greater than or equal
+// to x and that is not in the bitmap. The boolean is false if there is no
+// such value.
+func (rb *Bitmap) NextAbsentValue(x uint64) (uint64, bool) {
+	ra := &rb.highlowcontainer
+	hb := highbits(x)
+	i := ra.getIndex(hb)
+	if i < 0 {
+		return x, true
+	}
+	low := lowbits(x)
+	for {
+		if v := ra.containers[i].NextAbsentValue(low); v >= 0 {
+			return uint64(ra.keys[i])<<32 | uint64(v), true
+		}
+		// the container is full from low onwards, look at the next key
+		if hb == maxUint32 {
+			return 0, false
+		}
+		hb++
+		i++
+		if i == len(ra.keys) || ra.keys[i] != hb {
+			return uint64(hb) << 32, true
+		}
+		low = 0
+	}
+}
+
+// PreviousAbsentValue returns the largest value that is smaller than or equal
+// to x and that is not in the bitmap. The boolean is false if there is no
+// such value.
+func (rb *Bitmap) PreviousAbsentValue(x uint64) (uint64, bool) {
+	ra := &rb.highlowcontainer
+	hb := highbits(x)
+	i := ra.getIndex(hb)
+	if i < 0 {
+		return x, true
+	}
+	low := lowbits(x)
+	for {
+		if v := ra.containers[i].PreviousAbsentValue(low); v >= 0 {
+			return uint64(ra.keys[i])<<32 | uint64(v), true
+		}
+		// the container is full up to low, look at the previous key
+		if hb == 0 {
+			return 0, false
+		}
+		hb--
+		i--
+		if i < 0 || ra.keys[i] != hb {
+			return uint64(hb)<<32 | maxLowBit, true
+		}
+		low = maxLowBit
+	}
+}
+
 // Contains returns true if the integer is contained in the bitmap
 func (rb *Bitmap) Contains(x uint64) bool {
 	hb := highbits(x)
@@ -269,6 +565,53 @@ func (rb *Bitmap) Equals(o interface{}) bool {
 	return false
 }
 
+// AddOffset adds the value 'offset' to each and every value in a bitmap, generating a new bitmap in the process
+// If offset + element is outside of the range [0,2^64), that the element will be dropped
+func AddOffset(x *Bitmap, offset int64) (answer *Bitmap) {
+	// the offset is split into an offset of the 32-bit keys and an offset
+	// within the 32-bit bitmaps, both rounding towards negative infinity
+	keyOffset := offset >> 32
+	inOffset := int64(uint32(offset))
+
+	if inOffset == 0 {
+		answer = New()
+		for pos := 0; pos < x.highlowcontainer.size(); pos++ {
+			key := int64(x.highlowcontainer.getKeyAtIndex(pos)) + keyOffset
+			if key >= 0 && key <= maxUint32 {
+				answer.highlowcontainer.appendContainer(uint32(key), x.highlowcontainer.getContainerAtIndex(pos).Clone(), false)
+			}
+		}
+		return answer
+	}
+
+	answer = New()
+	for pos := 0; pos < x.highlowcontainer.size(); pos++ {
+		key := int64(x.highlowcontainer.getKeyAtIndex(pos)) + keyOffset
+		c := x.highlowcontainer.getContainerAtIndex(pos)
+
+		// the values staying below 2^32 keep the key, the others move to the next one
+		if key >= 0 && key <= maxUint32 {
+			low := roaring.AddOffset64(c, inOffset)
+			if !low.IsEmpty() {
+				curSize := answer.highlowcontainer.size()
+				if curSize > 0 && answer.highlowcontainer.getKeyAtIndex(curSize-1) == uint32(key) {
+					answer.highlowcontainer.getContainerAtIndex(curSize - 1).Or(low)
+				} else {
+					answer.highlowcontainer.appendContainer(uint32(key), low, false)
+				}
+			}
+		}
+		if key+1 >= 0 && key+1 <= maxUint32 {
+			high := roaring.AddOffset64(c, inOffset-(1<<32))
+			if !high.IsEmpty() {
+				answer.highlowcontainer.appendContainer(uint32(key+1), high, false)
+			}
+		}
+	}
+
+	return answer
+}
+
 // Add the integer x to the bitmap
 func (rb *Bitmap) Add(x uint64) {
 	hb := highbits(x)
@@ -385,6 +728,88 @@ func (rb *Bitmap) Select(x uint64) (uint64, error) {
 	return 0, fmt.Errorf("can't find %dth integer in a bitmap with only %d items", x, cardinality)
 }
 
+// RangeCardinality returns the number of integers in [rangeStart, rangeEnd).
+// Bitmaps entirely inside the range contribute their stored cardinality.
+func (rb *Bitmap) RangeCardinality(rangeStart, rangeEnd uint64) uint64 {
+	if rangeStart >= rangeEnd {
+		return 0
+	}
+	hbStart := highbits(rangeStart)
+	hbLast := highbits(rangeEnd - 1)
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	answer := uint64(0)
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		answer += ra.containers[i].RangeCardinality(containerStart, containerEnd)
+	}
+	return answer
+}
+
+// ContainsRange returns true if all the integers in [rangeStart, rangeEnd)
+// are in the bitmap. An empty range is always contained.
+func (rb *Bitmap) ContainsRange(rangeStart, rangeEnd uint64) bool {
+	if rangeStart >= rangeEnd {
+		return true
+	}
+	hbStart := highbits(rangeStart)
+	hbLast := highbits(rangeEnd - 1)
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 || uint64(len(ra.keys)-i) < uint64(hbLast-hbStart)+1 || ra.keys[i+int(hbLast-hbStart)] != hbLast {
+		// keys are sorted and unique: all the keys in between must be present
+		return false
+	}
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		if !ra.containers[i].ContainsRange(containerStart, containerEnd) {
+			return false
+		}
+	}
+	return true
+}
+
+// IntersectsRange returns true if at least one integer in [rangeStart, rangeEnd)
+// is in the bitmap.
+func (rb *Bitmap) IntersectsRange(rangeStart, rangeEnd uint64) bool {
+	if rangeStart >= rangeEnd {
+		return false
+	}
+	hbStart := highbits(rangeStart)
+	hbLast := highbits(rangeEnd - 1)
+
+	ra := &rb.highlowcontainer
+	i := ra.getIndex(hbStart)
+	if i < 0 {
+		i = -i - 1
+	}
+	for ; i < len(ra.keys) && ra.keys[i] <= hbLast; i++ {
+		containerStart, containerEnd := containerRange(ra.keys[i], hbStart, hbLast, rangeStart, rangeEnd)
+		if ra.containers[i].IntersectsRange(containerStart, containerEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerRange returns the part [start, end) of [rangeStart, rangeEnd)
+// that falls within the container with the given key.
+func containerRange(key, hbStart, hbLast uint32, rangeStart, rangeEnd uint64) (uint64, uint64) {
+	start, end := uint64(0), uint64(maxLowBit)+1
+	if key == hbStart {
+		start = uint64(lowbits(rangeStart))
+	}
+	if key == hbLast {
+		end = uint64(lowbits(rangeEnd-1)) + 1
+	}
+	return start, end
+}
+
 // And computes the intersection between two bitmaps and stores the result in the current bitmap
 func (rb *Bitmap) And(x2 *Bitmap) {
 	pos1 := 0
@@ -534,6 +959,136 @@ main:
 	return answer
 }
 
+// AndNotCardinality returns the cardinality of the difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) AndNotCardinality(x2 *Bitmap) uint64 {
+	pos1 := 0
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	answer := uint64(0)
+main:
+	for {
+		if (pos1 < length1) && (pos2 < length2) {
+			s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+			s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+			for {
+				if s1 < s2 {
+					answer += rb.highlowcontainer.getContainerAtIndex(pos1).GetCardinality()
+					pos1++
+					if pos1 == length1 {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+				} else if s1 > s2 {
+					pos2 = x2.highlowcontainer.advanceUntil(s1, pos2)
+					if pos2 == length2 {
+						break main
+					}
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				} else {
+					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+					answer += c1.AndNotCardinality(c2)
+					pos1++
+					pos2++
+					if (pos1 == length1) || (pos2 == length2) {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				}
+			}
+		} else {
+			break
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer += rb.highlowcontainer.getContainerAtIndex(pos1).GetCardinality()
+	}
+	return answer
+}
+
+// XorCardinality returns the cardinality of the symmetric difference between two bitmaps, bitmaps are not modified
+func (rb *Bitmap) XorCardinality(x2 *Bitmap) uint64 {
+	pos1 := 0
+	pos2 := 0
+	length1 := rb.highlowcontainer.size()
+	length2 := x2.highlowcontainer.size()
+	answer := uint64(0)
+main:
+	for {
+		if (pos1 < length1) && (pos2 < length2) {
+			s1 := rb.highlowcontainer.getKeyAtIndex(pos1)
+			s2 := x2.highlowcontainer.getKeyAtIndex(pos2)
+
+			for {
+				if s1 < s2 {
+					answer += rb.highlowcontainer.getContainerAtIndex(pos1).GetCardinality()
+					pos1++
+					if pos1 == length1 {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+				} else if s1 > s2 {
+					answer += x2.highlowcontainer.getContainerAtIndex(pos2).GetCardinality()
+					pos2++
+					if pos2 == length2 {
+						break main
+					}
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				} else {
+					c1 := rb.highlowcontainer.getContainerAtIndex(pos1)
+					c2 := x2.highlowcontainer.getContainerAtIndex(pos2)
+					answer += c1.XorCardinality(c2)
+					pos1++
+					pos2++
+					if (pos1 == length1) || (pos2 == length2) {
+						break main
+					}
+					s1 = rb.highlowcontainer.getKeyAtIndex(pos1)
+					s2 = x2.highlowcontainer.getKeyAtIndex(pos2)
+				}
+			}
+		} else {
+			break
+		}
+	}
+	for ; pos1 < length1; pos1++ {
+		answer += rb.highlowcontainer.getContainerAtIndex(pos1).GetCardinality()
+	}
+	for ; pos2 < length2; pos2++ {
+		answer += x2.highlowcontainer.getContainerAtIndex(pos2).GetCardinality()
+	}
+	return answer
+}
+
+// JaccardIndex returns the Jaccard similarity coefficient of two bitmaps,
+// that is the size of their intersection divided by the size of their union.
+// By convention, the index of two empty bitmaps is 0.
+func (rb *Bitmap) JaccardIndex(x2 *Bitmap) float64 {
+	intersection := rb.AndCardinality(x2)
+	union := rb.GetCardinality() + x2.GetCardinality() - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// OverlapCoefficient returns the size of the intersection of two bitmaps
+// divided by the size of the smaller of the two.
+// By convention, the coefficient is 0 when either bitmap is empty.
+func (rb *Bitmap) OverlapCoefficient(x2 *Bitmap) float64 {
+	smallest := rb.GetCardinality()
+	if c := x2.GetCardinality(); c < smallest {
+		smallest = c
+	}
+	if smallest == 0 {
+		return 0
+	}
+	return float64(rb.AndCardinality(x2)) / float64(smallest)
+}
+
 // Intersects checks whether two bitmap intersects, bitmaps are not modified
 func (rb *Bitmap) Intersects(x2 *Bitmap) bool {
 	pos1 := 0
@@ -597,7 +1152,7 @@ func (rb *Bitmap) Xor(x2 *Bitmap) {
 					break
 				}
 			} else if s1 > s2 {
-				c := x2.highlowcontainer.getWritableContainerAtIndex(pos2)
+				c := x2.highlowcontainer.getContainerAtIndex(pos2).Clone()
 				rb.highlowcontainer.insertNewKeyValueAt(pos1, x2.highlowcontainer.getKeyAtIndex(pos2), c)
 				length1++
 				pos1++
@@ -1,6 +1,9 @@
 package roaring64
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"math"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/willf/bitset"
 )
 
@@ -1914,19 +1918,91 @@ func TestSerialization(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, newBmp.Equals(bmp))
 
-	//bufBmp := New()
-	//_, err = bufBmp.FromBuffer(buf)
-	//assert.Nil(t, err)
-	//assert.True(t, bufBmp.Equals(bmp))
+	bufBmp := New()
+	_, err = bufBmp.FromBuffer(buf)
+	assert.Nil(t, err)
+	assert.True(t, bufBmp.Equals(bmp))
+
+	var base64 string
+	base64, err = bufBmp.ToBase64()
+	assert.Nil(t, err)
+
+	base64Bmp := New()
+	_, err = base64Bmp.FromBase64(base64)
+	assert.Nil(t, err)
+	assert.True(t, base64Bmp.Equals(bmp))
+}
+
+func TestGetSerializedSizeInBytes64(t *testing.T) {
+	bitmaps := []*Bitmap{
+		New(),
+		BitmapOf(1, 2, 3),
+		BitmapOf(123, 0xA00000000A, 0xAFFFFFFF7, 0xFFFFFFFFF, math.MaxUint64),
+	}
+	rb := New()
+	rb.AddRange(0, 100000)
+	rb.AddRange(1<<40, 1<<40+70000)
+	rb.RunOptimize()
+	bitmaps = append(bitmaps, rb)
+
+	for _, bm := range bitmaps {
+		buf, err := bm.ToBytes()
+		require.NoError(t, err)
+		assert.EqualValues(t, len(buf), bm.GetSerializedSizeInBytes())
+	}
+}
+
+func TestFromBuffer64(t *testing.T) {
+	rb := New()
+	rb.AddRange(0, 100000)
+	rb.AddMany([]uint64{1 << 33, 1<<33 + 7, 1<<40 + 3})
+	for i := uint64(0); i < 5000; i++ {
+		rb.Add(1<<50 + 3*i)
+	}
+	buf, err := rb.ToBytes()
+	require.NoError(t, err)
+	original := append([]byte(nil), buf...)
 
-	//var base64 string
-	//base64, err = bufBmp.ToBase64()
-	//assert.Nil(t, err)
+	bm := New()
+	p, err := bm.FromBuffer(buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(buf), p)
+	assert.True(t, bm.Equals(rb))
+
+	// the containers refer to the buffer and are copied when modified
+	bm.Remove(5)
+	bm.Add(1<<50 + 1)
+	bm.RemoveRange(1<<33, 1<<34)
+	assert.Equal(t, original, buf)
+	assert.False(t, bm.Contains(5))
+	assert.True(t, bm.Contains(1<<50+1))
+	assert.False(t, bm.Contains(1<<33+7))
+	assert.EqualValues(t, rb.GetCardinality()-2, bm.GetCardinality())
+
+	// a short buffer is an error
+	_, err = New().FromBuffer(buf[:len(buf)-1])
+	assert.Error(t, err)
+	_, err = New().FromBuffer(buf[:6])
+	assert.Error(t, err)
+}
 
-	//base64Bmp := New()
-	//_, err = base64Bmp.FromBase64(base64)
-	//assert.Nil(t, err)
-	//assert.True(t, base64Bmp.Equals(bmp))
+func TestFromBufferCloneCopyOnWriteContainers64(t *testing.T) {
+	rb := New()
+	rb.AddRange(0, 3000)
+	rb.AddRange(1<<40, 1<<40+3000)
+	buf, err := rb.ToBytes()
+	require.NoError(t, err)
+
+	bm := New()
+	_, err = bm.FromBuffer(buf)
+	require.NoError(t, err)
+	bm.CloneCopyOnWriteContainers()
+
+	// the bitmap no longer depends on the buffer
+	for i := range buf {
+		buf[i] = 0
+	}
+	assert.True(t, bm.Equals(rb))
 }
 
 func TestAddCheckedRemove64(t *testing.T) {
@@ -2004,3 +2080,305 @@ func IntsEquals(a, b []uint64) bool {
 	}
 	return true
 }
+
+func TestNextAndPreviousValue64(t *testing.T) {
+	rb := BitmapOf(5, 1<<32-1, 1<<32, 1<<40+7, math.MaxUint64)
+
+	v, ok := rb.NextValue(0)
+	assert.True(t, ok)
+	assert.EqualValues(t, 5, v)
+	v, ok = rb.NextValue(6)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<32-1), v)
+	v, ok = rb.NextValue(1<<32 + 1)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<40+7), v)
+	v, ok = rb.NextValue(math.MaxUint64)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(math.MaxUint64), v)
+
+	v, ok = rb.PreviousValue(4)
+	assert.False(t, ok)
+	v, ok = rb.PreviousValue(1<<40 + 6)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<32), v)
+	v, ok = rb.PreviousValue(math.MaxUint64 - 1)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<40+7), v)
+
+	v, ok = rb.NextAbsentValue(1<<32 - 1)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<32+1), v)
+	v, ok = rb.PreviousAbsentValue(1 << 32)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<32-2), v)
+	v, ok = rb.NextAbsentValue(math.MaxUint64)
+	assert.False(t, ok)
+
+	empty := New()
+	_, ok = empty.NextValue(0)
+	assert.False(t, ok)
+	_, ok = empty.PreviousValue(math.MaxUint64)
+	assert.False(t, ok)
+	v, ok = empty.NextAbsentValue(42)
+	assert.True(t, ok)
+	assert.EqualValues(t, 42, v)
+}
+
+func TestAbsentValueAcrossFullContainers64(t *testing.T) {
+	rb := New()
+	rb.AddRange(1<<32, 3<<32)
+	rb.AddRange(5<<32, 6<<32)
+
+	v, ok := rb.NextAbsentValue(1<<32 + 10)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(3<<32), v)
+	v, ok = rb.PreviousAbsentValue(3<<32 - 1)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(1<<32-1), v)
+	v, ok = rb.PreviousAbsentValue(6<<32 - 1)
+	assert.True(t, ok)
+	assert.EqualValues(t, uint64(5<<32-1), v)
+
+	rb.AddRange(0, 1<<32)
+	_, ok = rb.PreviousAbsentValue(3<<32 - 1)
+	assert.False(t, ok)
+}
+
+func TestRangeCardinalityAndContainment64(t *testing.T) {
+	rb := New()
+	rb.AddRange(1<<32-5, 1<<32+5)
+	rb.AddRange(3<<32, 5<<32)
+	rb.Add(math.MaxUint64)
+
+	assert.EqualValues(t, 10, rb.RangeCardinality(0, 2<<32))
+	assert.EqualValues(t, 5, rb.RangeCardinality(1<<32, 2<<32))
+	assert.EqualValues(t, 2<<32, rb.RangeCardinality(3<<32, 6<<32))
+	assert.EqualValues(t, 0, rb.RangeCardinality(5<<32, math.MaxUint64))
+	assert.EqualValues(t, 0, rb.RangeCardinality(7, 7))
+
+	assert.True(t, rb.ContainsRange(1<<32-5, 1<<32+5))
+	assert.False(t, rb.ContainsRange(1<<32-6, 1<<32+5))
+	assert.False(t, rb.ContainsRange(1<<32-5, 1<<32+6))
+	assert.True(t, rb.ContainsRange(3<<32+7, 5<<32))
+	assert.False(t, rb.ContainsRange(3<<32, 5<<32+1))
+	assert.False(t, rb.ContainsRange(1<<32, 3<<32+1))
+	assert.True(t, rb.ContainsRange(9, 9))
+
+	assert.True(t, rb.IntersectsRange(0, 1<<32-4))
+	assert.False(t, rb.IntersectsRange(0, 1<<32-5))
+	assert.False(t, rb.IntersectsRange(1<<32+5, 3<<32))
+	assert.True(t, rb.IntersectsRange(1<<32+5, 3<<32+1))
+	assert.False(t, rb.IntersectsRange(6<<32, math.MaxUint64))
+	assert.True(t, rb.IntersectsRange(5<<32-1, 6<<32))
+}
+
+func TestAndNotAndXorCardinality64(t *testing.T) {
+	rb1 := BitmapOf(1, 2, 3, 1<<32, 1<<32+1, 5<<32)
+	rb1.AddRange(7<<32, 7<<32+100000)
+	rb2 := BitmapOf(2, 3, 4, 1<<32+1, 6<<32)
+	rb2.AddRange(7<<32+50000, 7<<32+200000)
+
+	assert.Equal(t, AndNot(rb1, rb2).GetCardinality(), rb1.AndNotCardinality(rb2))
+	assert.Equal(t, AndNot(rb2, rb1).GetCardinality(), rb2.AndNotCardinality(rb1))
+	assert.Equal(t, Xor(rb1, rb2).GetCardinality(), rb1.XorCardinality(rb2))
+
+	intersection := float64(And(rb1, rb2).GetCardinality())
+	assert.InDelta(t, intersection/float64(Or(rb1, rb2).GetCardinality()), rb1.JaccardIndex(rb2), 1e-12)
+	assert.InDelta(t, intersection/float64(rb1.GetCardinality()), rb1.OverlapCoefficient(rb2), 1e-12)
+	assert.Equal(t, 0.0, New().JaccardIndex(New()))
+}
+
+func TestDeserializationOptions64(t *testing.T) {
+	rb := BitmapOf(1, 5, 1<<32, 1<<32+7, 3<<40)
+	rb.AddRange(1<<33, 1<<33+100000)
+	buf, err := rb.ToBytes()
+	assert.NoError(t, err)
+	containers := uint64(0)
+	for _, c := range rb.highlowcontainer.containers {
+		containers += uint64(c.Stats().Containers)
+	}
+
+	for _, opts := range []roaring.DeserializationOptions{
+		{},
+		{MaxContainers: containers, MaxBytes: uint64(len(buf)), MaxCardinality: rb.GetCardinality(), Validate: true},
+	} {
+		decoded := NewBitmap()
+		_, err := decoded.FromBufferWithOptions(buf, opts)
+		assert.NoError(t, err)
+		assert.True(t, rb.Equals(decoded))
+	}
+
+	limits := []struct {
+		opts  roaring.DeserializationOptions
+		limit string
+	}{
+		{roaring.DeserializationOptions{MaxContainers: containers - 1}, "MaxContainers"},
+		{roaring.DeserializationOptions{MaxContainers: 2}, "MaxContainers"},
+		{roaring.DeserializationOptions{MaxBytes: uint64(len(buf)) - 1}, "MaxBytes"},
+		{roaring.DeserializationOptions{MaxBytes: 10}, "MaxBytes"},
+		{roaring.DeserializationOptions{MaxCardinality: rb.GetCardinality() - 1}, "MaxCardinality"},
+		{roaring.DeserializationOptions{MaxCardinality: 3}, "MaxCardinality"},
+	}
+	for _, l := range limits {
+		decoded := NewBitmap()
+		_, err := decoded.ReadFromWithOptions(bytes.NewReader(buf), l.opts)
+		limitErr, ok := err.(*roaring.DeserializationLimitError)
+		if assert.True(t, ok, "%v", err) {
+			assert.Equal(t, l.limit, limitErr.Limit)
+			assert.True(t, limitErr.Value > limitErr.Max, "%v", limitErr)
+		}
+		assert.True(t, decoded.IsEmpty())
+	}
+
+	// a huge number of 32-bit keys is rejected before allocating them
+	huge := make([]byte, 8)
+	binary.LittleEndian.PutUint64(huge, 1<<40)
+	_, err = NewBitmap().FromBufferWithOptions(huge, roaring.DeserializationOptions{MaxBytes: 1 << 20})
+	assert.IsType(t, &roaring.DeserializationLimitError{}, err)
+
+	// 4 bytes per key would overflow the required number of bytes
+	binary.LittleEndian.PutUint64(huge, 1<<62)
+	_, err = NewBitmap().FromBufferWithOptions(huge, roaring.DeserializationOptions{MaxBytes: 1 << 20})
+	if assert.IsType(t, &roaring.DeserializationLimitError{}, err) {
+		assert.Equal(t, uint64(math.MaxUint64), err.(*roaring.DeserializationLimitError).Value)
+	}
+
+	// without a limit on the bytes, the size is not used to allocate memory
+	binary.LittleEndian.PutUint64(huge, math.MaxUint64)
+	for _, opts := range []roaring.DeserializationOptions{{MaxCardinality: 10}, {Validate: true}} {
+		_, err = NewBitmap().ReadFromWithOptions(bytes.NewReader(huge), opts)
+		assert.Error(t, err)
+	}
+}
+
+func TestValidate64(t *testing.T) {
+	rb := BitmapOf(1, 1<<32, 1<<33)
+	assert.NoError(t, rb.Validate())
+	rb.highlowcontainer.keys[0] = 3
+	assert.Error(t, rb.Validate())
+
+	rb = BitmapOf(1, 1<<32)
+	rb.highlowcontainer.containers[1] = roaring.NewBitmap()
+	assert.Error(t, rb.Validate())
+}
+
+func TestIterate64(t *testing.T) {
+	rb := NewBitmap()
+	rb.AddRange(150000, 450000)
+	rb.AddMany([]uint64{1 << 32, 1<<32 + 5, 7 << 40, math.MaxUint64})
+
+	for _, optimize := range []bool{false, true} {
+		if optimize {
+			rb.RunOptimize()
+		}
+		var values []uint64
+		rb.Iterate(func(x uint64) bool {
+			values = append(values, x)
+			return true
+		})
+		assert.Equal(t, rb.ToArray(), values)
+	}
+}
+
+func TestIterateHalt64(t *testing.T) {
+	rb := NewBitmap()
+	rb.AddRange(150000, 450000)
+	rb.AddMany([]uint64{1 << 32, 1<<32 + 5, 7 << 40})
+
+	for _, stopAt := range []uint64{1, 300000, rb.GetCardinality() - 1} {
+		var values []uint64
+		rb.Iterate(func(x uint64) bool {
+			values = append(values, x)
+			return uint64(len(values)) < stopAt
+		})
+		assert.Equal(t, rb.ToArray()[:stopAt], values)
+	}
+}
+
+func TestAddOffset64(t *testing.T) {
+	arr := []uint64{5580, 33722, 44031, 57276, 83097, 1<<32 - 3, 1 << 32, 3<<32 + 17, math.MaxUint64 - 1}
+	offsets := []int64{0, 1, 3, 64, 100, 25000, -25000, -83097, 1 << 32, -(1 << 32), 1<<32 + 5, -(1<<32 + 5), 1<<40 - 7, math.MaxUint32, -math.MaxUint32, math.MaxInt64, math.MinInt64}
+
+	bmp := BitmapOf(arr...)
+	runs := bmp.Clone()
+	runs.AddRange(1<<32-70000, 1<<32+70000)
+	runs.RunOptimize()
+	// bitmap containers, within a 32-bit key and across 32-bit keys
+	dense := bmp.Clone()
+	for i := uint64(0); i < 20000; i++ {
+		dense.Add(3 * i)
+		dense.Add(1<<32 - 30000 + 3*i)
+	}
+
+	for _, bm := range []*Bitmap{bmp, runs, dense} {
+		for _, offset := range offsets {
+			var expected []uint64
+			bm.Iterate(func(x uint64) bool {
+				if offset >= 0 {
+					if y := x + uint64(offset); y >= x {
+						expected = append(expected, y)
+					}
+				} else if uint64(-offset) <= x {
+					expected = append(expected, x-uint64(-offset))
+				}
+				return true
+			})
+
+			cop := AddOffset(bm, offset)
+			assert.EqualValues(t, len(expected), cop.GetCardinality(), "offset %d", offset)
+			assert.True(t, cop.Equals(BitmapOf(expected...)), "offset %d", offset)
+		}
+	}
+
+	// the result does not share its 32-bit bitmaps with the input
+	cop := AddOffset(bmp, 0)
+	cop.Add(7)
+	assert.False(t, bmp.Contains(7))
+}
+
+func TestBase64Interop32(t *testing.T) {
+	values := []uint32{1, 2, 3, 4, 5, 100, 1000, 70000, math.MaxUint32}
+	rb32 := roaring.BitmapOf(values...)
+	rb := New()
+	for _, v := range values {
+		rb.Add(uint64(v))
+	}
+
+	bstr, err := rb.ToBase64()
+	require.NoError(t, err)
+	newrb := New()
+	_, err = newrb.FromBase64(bstr)
+	require.NoError(t, err)
+	assert.True(t, rb.Equals(newrb))
+
+	// the only bucket holds the 32-bit serialization of the values
+	data, err := base64.StdEncoding.DecodeString(bstr)
+	require.NoError(t, err)
+	bytes32, err := rb32.ToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, data[:12])
+	assert.Equal(t, bytes32, data[12:])
+
+	str32, err := rb32.ToBase64()
+	require.NoError(t, err)
+	decoded32 := roaring.New()
+	_, err = decoded32.FromBase64(str32)
+	require.NoError(t, err)
+	assert.Equal(t, decoded32.ToArray(), values)
+
+	_, err = New().FromBase64("not base64!")
+	assert.Error(t, err)
+}
+
+func TestXorDoesNotShareContainers64(t *testing.T) {
+	rb := BitmapOf(1, 3<<32)
+	other := BitmapOf(2, 1<<32, 5<<32)
+	rb.Xor(other)
+	assert.Equal(t, []uint64{1, 2, 1 << 32, 3 << 32, 5 << 32}, rb.ToArray())
+
+	// the bitmaps of other inserted in rb are copies
+	rb.Remove(1 << 32)
+	rb.Add(1<<32 + 1)
+	assert.Equal(t, []uint64{2, 1 << 32, 5 << 32}, other.ToArray())
+}
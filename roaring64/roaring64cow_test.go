@@ -1729,14 +1729,14 @@ func TestCloneCOWContainers(t *testing.T) {
 	buf := &bytes.Buffer{}
 	rb.WriteTo(buf)
 
-	//newRb1 := NewBitmap()
-	//newRb1.FromBuffer(buf.Bytes())
-	//newRb1.CloneCopyOnWriteContainers()
+	newRb1 := NewBitmap()
+	newRb1.FromBuffer(buf.Bytes())
+	newRb1.CloneCopyOnWriteContainers()
 
 	rb2 := NewBitmap()
 	rb2.AddRange(uint64(3000), uint64(6000))
 	buf.Reset()
 	rb2.WriteTo(buf)
 
-	//assert.EqualValues(t, rb.ToArray(), newRb1.ToArray())
+	assert.EqualValues(t, rb.ToArray(), newRb1.ToArray())
 }
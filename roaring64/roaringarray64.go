@@ -175,6 +175,9 @@ func (ra *roaringArray64) cloneCopyOnWriteContainers() {
 		if needCopyOnWrite {
 			ra.containers[i] = ra.containers[i].Clone()
 			ra.needCopyOnWrite[i] = false
+		} else {
+			// the containers of the bitmap may still refer to a buffer
+			ra.containers[i].CloneCopyOnWriteContainers()
 		}
 	}
 }
@@ -0,0 +1,100 @@
+import java.io.DataOutputStream;
+import java.io.FileOutputStream;
+import java.io.IOException;
+import java.io.PrintWriter;
+
+import org.roaringbitmap.RoaringBitmap;
+import org.roaringbitmap.bsi.longlong.Roaring64NavigableMapSliceIndex;
+import org.roaringbitmap.longlong.Roaring64NavigableMap;
+
+/**
+ * Writes the golden files of roaring64/testdata with the Java RoaringBitmap
+ * library, from the same values as the Go tests. See README.md.
+ */
+public class GoldenFiles {
+
+  public static void main(String[] args) throws IOException {
+    String dir = args.length > 0 ? args[0] : ".";
+    writePortable(dir);
+    writeBSI(dir);
+    writeGeneratedBy(dir);
+  }
+
+  // the values of portableGoldenBitmaps in portable_golden_test.go
+  static void writePortable(String dir) throws IOException {
+    Roaring64NavigableMap.SERIALIZATION_MODE = Roaring64NavigableMap.SERIALIZATION_MODE_PORTABLE;
+
+    writeMap(dir + "/portable_empty.bin", new Roaring64NavigableMap(false));
+
+    Roaring64NavigableMap bm = new Roaring64NavigableMap(false);
+    for (long i = 0; i < 1000; i++) {
+      bm.addLong(3 * i);
+    }
+    writeMap(dir + "/portable_32bitvals.bin", bm);
+
+    bm = new Roaring64NavigableMap(false);
+    for (long i = 0; i < 10; i++) {
+      for (long j = 0; j < 1000; j++) {
+        bm.addLong(i << 32 | j * 7);
+      }
+    }
+    bm.add(1L << 40, (1L << 40) + 100000);
+    bm.runOptimize();
+    writeMap(dir + "/portable_spreadvals.bin", bm);
+
+    bm = new Roaring64NavigableMap(false);
+    for (long i = 0; i < 1000; i++) {
+      bm.addLong(-1L - i);
+      bm.addLong(-1L - (1L << 32) - 5 * i);
+    }
+    for (long i = 0; i < 10000; i++) {
+      bm.addLong(Long.MIN_VALUE + 3 * i);
+    }
+    writeMap(dir + "/portable_highvals.bin", bm);
+  }
+
+  static void writeMap(String path, Roaring64NavigableMap bm) throws IOException {
+    try (DataOutputStream out = new DataOutputStream(new FileOutputStream(path))) {
+      bm.serialize(out);
+    }
+  }
+
+  // the values of javaGoldenBSIs in java_golden_test.go
+  static void writeBSI(String dir) throws IOException {
+    // the slice index writes its bitmaps in the legacy mode
+    Roaring64NavigableMap.SERIALIZATION_MODE = Roaring64NavigableMap.SERIALIZATION_MODE_LEGACY;
+
+    writeBSI(dir + "/java_bsi64_empty.bin", new Roaring64NavigableMapSliceIndex());
+
+    Roaring64NavigableMapSliceIndex bsi = new Roaring64NavigableMapSliceIndex(0, 999);
+    for (long i = 0; i < 1000; i++) {
+      bsi.setValue(3 * i, i * i % 1000);
+    }
+    bsi.runOptimize();
+    writeBSI(dir + "/java_bsi64.bin", bsi);
+
+    bsi = new Roaring64NavigableMapSliceIndex(5, 1L << 62);
+    for (long i = 0; i < 100; i++) {
+      bsi.setValue(i << 30, (1L << 62) - i);
+      bsi.setValue(Long.MIN_VALUE | i, i << 33);
+    }
+    bsi.setValue(7, 5);
+    writeBSI(dir + "/java_bsi64_wide.bin", bsi);
+  }
+
+  static void writeBSI(String path, Roaring64NavigableMapSliceIndex bsi) throws IOException {
+    try (DataOutputStream out = new DataOutputStream(new FileOutputStream(path))) {
+      bsi.serialize(out);
+    }
+  }
+
+  // GENERATED_BY records the library and the JVM that wrote the files
+  static void writeGeneratedBy(String dir) throws IOException {
+    String version = RoaringBitmap.class.getPackage().getImplementationVersion();
+    try (PrintWriter out = new PrintWriter(dir + "/GENERATED_BY")) {
+      out.println("generator: GoldenFiles.java");
+      out.println("library: org.roaringbitmap:RoaringBitmap " + (version != null ? version : "unknown"));
+      out.println("java: " + System.getProperty("java.version"));
+    }
+  }
+}
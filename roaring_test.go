@@ -5,6 +5,7 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"sort"
 	"strconv"
 	"testing"
 	"unsafe"
@@ -157,6 +158,21 @@ func TestRoaringBitmapAddOffset(t *testing.T) {
 			offset:   0,
 			expected: []uint32{5580, 33722, 44031, 57276, 83097},
 		},
+		{
+			arr:      []uint32{5580, 33722, 44031, 57276, 83097, MaxUint32 - 1},
+			offset:   65536,
+			expected: []uint32{71116, 99258, 109567, 122812, 148633},
+		},
+		{
+			arr:      []uint32{5580, 33722, 44031, 57276, 83097, MaxUint32 - 1},
+			offset:   -65536,
+			expected: []uint32{17561, MaxUint32 - 65537},
+		},
+		{
+			arr:      []uint32{5580, 83097, MaxUint32 - 24999, MaxUint32},
+			offset:   -(MaxUint32 - 25000),
+			expected: []uint32{1, 25000},
+		},
 	}
 
 	for _, c := range cases {
@@ -167,6 +183,7 @@ func TestRoaringBitmapAddOffset(t *testing.T) {
 
 		assert.EqualValues(t, len(c.expected), cop.GetCardinality())
 		assert.EqualValues(t, c.expected, cop.ToArray())
+		assert.NoError(t, cop.Validate())
 	}
 }
 
@@ -1628,6 +1645,47 @@ func TestNextMany(t *testing.T) {
 	}
 }
 
+func TestManyIteratorAdvanceIfNeeded(t *testing.T) {
+	bm := NewBitmap()
+	bm.AddRange(10, 20)                // run container
+	bm.AddMany([]uint32{70000, 70003}) // array container
+	for i := uint32(200000); i < 210000; i += 2 {
+		bm.Add(i) // bitmap container
+	}
+	bm.RunOptimize()
+	values := bm.ToArray()
+
+	for _, minval := range []uint32{0, 10, 15, 19, 20, 69999, 70001, 70003, 70004, 200000, 200063, 200064, 200065, 209998, 209999, 1 << 31} {
+		it := bm.ManyIterator()
+		it.AdvanceIfNeeded(minval)
+		var got []uint32
+		buf := make([]uint32, 7)
+		for n := it.NextMany(buf); n != 0; n = it.NextMany(buf) {
+			got = append(got, buf[:n]...)
+		}
+
+		var expected []uint32
+		for _, v := range values {
+			if v >= minval {
+				expected = append(expected, v)
+			}
+		}
+		assert.Equal(t, expected, got, "minval %d", minval)
+	}
+
+	// advancing backwards or within the values already read does nothing
+	it := bm.ManyIterator()
+	buf := make([]uint32, 3)
+	assert.Equal(t, 3, it.NextMany(buf))
+	it.AdvanceIfNeeded(5)
+	it.AdvanceIfNeeded(12)
+	assert.Equal(t, 3, it.NextMany(buf))
+	assert.Equal(t, []uint32{13, 14, 15}, buf)
+	it.AdvanceIfNeeded(200001)
+	assert.Equal(t, 3, it.NextMany(buf))
+	assert.Equal(t, []uint32{200002, 200004, 200006}, buf)
+}
+
 func TestBigRandom(t *testing.T) {
 	rTest(t, 15)
 	rTest(t, 100)
@@ -2497,3 +2555,360 @@ func BenchmarkArrayUnionThreshold(b *testing.B) {
 		})
 	}
 }
+
+// nextValueTestBitmaps covers array, bitmap and run containers,
+// including full containers and values at the container boundaries.
+func nextValueTestBitmaps() []*Bitmap {
+	sparse := BitmapOf(0, 3, 4, 5, 9, 65535, 65536, 70000, 3<<16+65535, MaxUint32)
+
+	dense := NewBitmap()
+	for i := uint32(0); i < 20000; i += 3 {
+		dense.Add(i)
+	}
+	dense.AddRange(300, 1000)
+
+	runs := NewBitmap()
+	runs.AddRange(10, 20)
+	runs.AddRange(65530, 65536+5)
+	runs.AddRange(MaxUint32-10, MaxUint32+1)
+	runs.RunOptimize()
+
+	consecutive := NewBitmap()
+	consecutive.AddRange(1<<16-100, 1<<16+100)
+
+	return []*Bitmap{NewBitmap(), sparse, dense, runs, consecutive}
+}
+
+func TestNextAndPreviousValue(t *testing.T) {
+	for _, rb := range nextValueTestBitmaps() {
+		values := rb.ToArray()
+		probes := []uint32{0, 1, 2, 8, 9, 15, 999, 1000, 65535, 65536, 65537, 4 << 16, MaxUint32 - 11, MaxUint32 - 1, MaxUint32}
+		for _, v := range values {
+			probes = append(probes, v-1, v, v+1)
+		}
+		for _, x := range probes {
+			expectedNext, expectedPrev := int64(-1), int64(-1)
+			i := sort.Search(len(values), func(i int) bool { return values[i] >= x })
+			if i < len(values) {
+				expectedNext = int64(values[i])
+			}
+			i = sort.Search(len(values), func(i int) bool { return values[i] > x })
+			if i > 0 {
+				expectedPrev = int64(values[i-1])
+			}
+			assert.Equal(t, expectedNext, rb.NextValue(x), "next %d", x)
+			assert.Equal(t, expectedPrev, rb.PreviousValue(x), "previous %d", x)
+
+			expectedNextAbsent := int64(-1)
+			for y := int64(x); y <= MaxUint32; y++ {
+				if !rb.Contains(uint32(y)) {
+					expectedNextAbsent = y
+					break
+				}
+			}
+			expectedPreviousAbsent := int64(-1)
+			for y := int64(x); y >= 0; y-- {
+				if !rb.Contains(uint32(y)) {
+					expectedPreviousAbsent = y
+					break
+				}
+			}
+			assert.Equal(t, expectedNextAbsent, rb.NextAbsentValue(x), "next absent %d", x)
+			assert.Equal(t, expectedPreviousAbsent, rb.PreviousAbsentValue(x), "previous absent %d", x)
+		}
+	}
+}
+
+func TestNextAbsentValueFullContainers(t *testing.T) {
+	for _, runOptimize := range []bool{false, true} {
+		rb := NewBitmap()
+		rb.AddRange(0, 3<<16)
+		rb.Add(3<<16 + 1)
+		if runOptimize {
+			rb.RunOptimize()
+		}
+		assert.EqualValues(t, 3<<16, rb.NextAbsentValue(5))
+		assert.EqualValues(t, -1, rb.PreviousAbsentValue(3<<16-1))
+		assert.EqualValues(t, 3<<16+2, rb.NextAbsentValue(3<<16+1))
+
+		full := NewBitmap()
+		full.AddRange(0, MaxRange)
+		if runOptimize {
+			full.RunOptimize()
+		}
+		assert.EqualValues(t, -1, full.NextAbsentValue(12345))
+		assert.EqualValues(t, -1, full.PreviousAbsentValue(12345))
+		full.Remove(1 << 20)
+		assert.EqualValues(t, 1<<20, full.NextAbsentValue(12345))
+		assert.EqualValues(t, 1<<20, full.PreviousAbsentValue(MaxUint32))
+	}
+}
+
+func TestRangeCardinalityAndContainment(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, rb := range nextValueTestBitmaps() {
+		values := rb.ToArray()
+		// counts the values in [start, end) the slow way
+		count := func(start, end uint64) uint64 {
+			lo := sort.Search(len(values), func(i int) bool { return uint64(values[i]) >= start })
+			hi := sort.Search(len(values), func(i int) bool { return uint64(values[i]) >= end })
+			if hi < lo {
+				return 0
+			}
+			return uint64(hi - lo)
+		}
+		ranges := [][2]uint64{{0, 0}, {0, 1}, {0, MaxRange}, {0, MaxRange + 10}, {10, 20}, {9, 21}, {65530, 65541},
+			{65536 - 100, 65536 + 100}, {MaxUint32 - 10, MaxRange}, {300, 1000}, {299, 1000}, {5, 3}}
+		for i := 0; i < 500; i++ {
+			start := uint64(r.Intn(1 << 18))
+			ranges = append(ranges, [2]uint64{start, start + uint64(r.Intn(1<<17))})
+		}
+		for _, rg := range ranges {
+			start, end := rg[0], rg[1]
+			expected := count(start, end)
+			length := uint64(0)
+			if end > start {
+				length = end - start
+			}
+			assert.Equal(t, expected, rb.RangeCardinality(start, end), "[%d, %d)", start, end)
+			assert.Equal(t, expected == length, rb.ContainsRange(start, end), "[%d, %d)", start, end)
+			assert.Equal(t, expected > 0, rb.IntersectsRange(start, end), "[%d, %d)", start, end)
+		}
+	}
+}
+
+func TestAndNotAndXorCardinality(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	randomBitmap := func() *Bitmap {
+		rb := NewBitmap()
+		for k := uint32(0); k < 6; k++ {
+			switch r.Intn(4) {
+			case 0: // array
+				for i := 0; i < 100; i++ {
+					rb.Add(k<<16 | uint32(r.Intn(1<<16)))
+				}
+			case 1: // bitmap
+				for i := 0; i < 20000; i++ {
+					rb.Add(k<<16 | uint32(r.Intn(1<<16)))
+				}
+			case 2: // runs
+				start := uint64(k<<16) + uint64(r.Intn(1<<15))
+				rb.AddRange(start, start+uint64(r.Intn(1<<15)))
+			}
+		}
+		if r.Intn(2) == 0 {
+			rb.RunOptimize()
+		}
+		return rb
+	}
+	for i := 0; i < 200; i++ {
+		rb1, rb2 := randomBitmap(), randomBitmap()
+		assert.Equal(t, AndNot(rb1, rb2).GetCardinality(), rb1.AndNotCardinality(rb2))
+		assert.Equal(t, AndNot(rb2, rb1).GetCardinality(), rb2.AndNotCardinality(rb1))
+		assert.Equal(t, Xor(rb1, rb2).GetCardinality(), rb1.XorCardinality(rb2))
+
+		union := Or(rb1, rb2).GetCardinality()
+		if union > 0 {
+			assert.InDelta(t, float64(And(rb1, rb2).GetCardinality())/float64(union), rb1.JaccardIndex(rb2), 1e-12)
+		}
+	}
+}
+
+func TestJaccardIndexAndOverlapCoefficient(t *testing.T) {
+	rb1 := BitmapOf(1, 2, 3, 4)
+	rb2 := BitmapOf(3, 4, 5, 6, 7, 8)
+
+	assert.Equal(t, 2.0/8.0, rb1.JaccardIndex(rb2))
+	assert.Equal(t, 2.0/4.0, rb1.OverlapCoefficient(rb2))
+	assert.Equal(t, 1.0, rb1.JaccardIndex(rb1))
+	assert.Equal(t, 0.0, NewBitmap().JaccardIndex(NewBitmap()))
+	assert.Equal(t, 0.0, rb1.OverlapCoefficient(NewBitmap()))
+	assert.EqualValues(t, 2, rb1.AndNotCardinality(rb2))
+	assert.EqualValues(t, 6, rb1.XorCardinality(rb2))
+}
+
+// rangesOf computes the maximal ranges of a sorted slice the slow way
+func rangesOf(values []uint32) []Range {
+	var ranges []Range
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		ranges = append(ranges, Range{Start: uint64(values[i]), End: uint64(values[j]) + 1})
+		i = j + 1
+	}
+	return ranges
+}
+
+func TestRangeIterator(t *testing.T) {
+	for _, rb := range nextValueTestBitmaps() {
+		expected := rangesOf(rb.ToArray())
+		assert.Equal(t, expected, rb.ToRanges())
+
+		copied := NewBitmap()
+		copied.AddRanges(rb.ToRanges())
+		assert.True(t, rb.Equals(copied))
+	}
+}
+
+func TestRangeIteratorMergesContainers(t *testing.T) {
+	rb := NewBitmap()
+	rb.AddRange(100, 3<<16+5)
+	rb.AddRange(5<<16, 6<<16)
+	rb.Add(6<<16 + 1)
+	rb.AddRange(MaxUint32-3, MaxRange)
+
+	expected := []Range{{100, 3<<16 + 5}, {5 << 16, 6 << 16}, {6<<16 + 1, 6<<16 + 2}, {MaxUint32 - 3, MaxRange}}
+	assert.Equal(t, expected, rb.ToRanges())
+
+	// the same ranges, but stored in array and bitmap containers
+	rb.RemoveRange(1<<16+1000, 1<<16+1001)
+	rb.RemoveRange(2<<16+3, 2<<16+4)
+	for i := uint32(0); i < 5000; i++ {
+		rb.Remove(1<<16 + 2000 + 2*i)
+	}
+	assert.Equal(t, rangesOf(rb.ToArray()), rb.ToRanges())
+
+	it := NewBitmap().RangeIterator()
+	assert.False(t, it.HasNext())
+}
+
+func TestAddRanges(t *testing.T) {
+	rb := BitmapOf(7, 1<<16+9, 9<<16)
+	ranges := []Range{
+		{Start: 100, End: 200},
+		{Start: 150, End: 250},
+		{Start: 250, End: 260},
+		{Start: 1<<16 - 5, End: 1<<16 + 5},
+		{Start: 3 << 16, End: 4<<16 + 1},
+		{Start: 9, End: 9},
+		{Start: MaxUint32, End: MaxRange},
+		{Start: 20, End: 30},
+	}
+	rb.AddRanges(ranges)
+
+	expected := BitmapOf(7, 1<<16+9, 9<<16)
+	for _, r := range ranges {
+		expected.AddRange(r.Start, r.End)
+	}
+	assert.True(t, expected.Equals(rb))
+	assert.Equal(t, expected.GetCardinality(), rb.GetCardinality())
+
+	assert.Panics(t, func() { rb.AddRanges([]Range{{Start: 0, End: MaxRange + 1}}) })
+}
+
+func TestValidateAfterOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(16))
+	randomBitmap := func() *Bitmap {
+		rb := NewBitmap()
+		for i := 0; i < r.Intn(20000); i++ {
+			rb.Add(uint32(r.Intn(1 << 19)))
+		}
+		start := uint64(r.Intn(1 << 19))
+		rb.AddRange(start, start+uint64(r.Intn(1<<17)))
+		if r.Intn(2) == 0 {
+			rb.RunOptimize()
+		}
+		return rb
+	}
+
+	rb := randomBitmap()
+	for step := 0; step < 500; step++ {
+		other := randomBitmap()
+		op := r.Intn(12)
+		switch op {
+		case 0:
+			rb.Add(uint32(r.Intn(1 << 19)))
+		case 1:
+			rb.Remove(uint32(r.Intn(1 << 19)))
+		case 2:
+			start := uint64(r.Intn(1 << 19))
+			rb.AddRange(start, start+uint64(r.Intn(1<<17)))
+		case 3:
+			start := uint64(r.Intn(1 << 19))
+			rb.RemoveRange(start, start+uint64(r.Intn(1<<17)))
+		case 4:
+			start := uint64(r.Intn(1 << 19))
+			rb.Flip(start, start+uint64(r.Intn(1<<17)))
+		case 5:
+			rb.Or(other)
+		case 6:
+			rb.And(other)
+		case 7:
+			rb.Xor(other)
+		case 8:
+			rb.AndNot(other)
+		case 9:
+			rb = FastOr(rb, other, randomBitmap())
+		case 10:
+			rb.RunOptimize()
+		case 11:
+			rb = HeapOr(rb, other)
+		}
+		assert.NoError(t, rb.Validate(), "operation %d", op)
+		if rb.IsEmpty() {
+			rb = randomBitmap()
+		}
+	}
+}
+
+func TestValidateDetectsCorruption(t *testing.T) {
+	assert.NoError(t, NewBitmap().Validate())
+
+	rb := BitmapOf(1, 2, 3, 1<<16, 2<<16)
+	rb.highlowcontainer.keys[1] = 2
+	assert.Error(t, rb.Validate())
+
+	rb = BitmapOf(1, 2, 3)
+	rb.highlowcontainer.containers[0].(*arrayContainer).content[1] = 1
+	assert.Error(t, rb.Validate())
+
+	rb = BitmapOf(1, 2, 3)
+	rb.highlowcontainer.containers[0] = newArrayContainer()
+	assert.Error(t, rb.Validate())
+
+	rb = NewBitmap()
+	rb.AddRange(0, 10000)
+	rb.highlowcontainer.containers[0] = rb.highlowcontainer.containers[0].(*runContainer16).toBitmapContainer()
+	assert.NoError(t, rb.Validate())
+	rb.highlowcontainer.containers[0].(*bitmapContainer).cardinality++
+	assert.Error(t, rb.Validate())
+
+	rb = NewBitmap()
+	rb.AddRange(0, 100)
+	rb.AddRange(200, 300)
+	rc := rb.highlowcontainer.containers[0].(*runContainer16)
+	rc.iv[1].start = 50
+	rc.card = 0
+	assert.Error(t, rb.Validate())
+	rc.iv[1] = interval16{start: 65500, length: 100}
+	assert.Error(t, rb.Validate())
+}
+
+func TestOrAppendsGreaterKeys(t *testing.T) {
+	rb := BitmapOf(1, 2)
+	rb.Or(BitmapOf(1<<16, 3<<16))
+	rb.Or(BitmapOf(3<<16+1, 5<<16))
+	rb.Or(BitmapOf(5<<16, 2))
+	assert.Equal(t, []uint32{1, 2, 1 << 16, 3 << 16, 3<<16 + 1, 5 << 16}, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+
+	// the appended containers are copies
+	other := BitmapOf(7 << 16)
+	rb.Or(other)
+	other.Add(7<<16 + 1)
+	assert.False(t, rb.Contains(7<<16+1))
+}
+
+func TestXorDoesNotShareContainers(t *testing.T) {
+	rb := BitmapOf(1, 3<<16)
+	other := BitmapOf(2, 1<<16, 5<<16)
+	rb.Xor(other)
+	assert.Equal(t, []uint32{1, 2, 1 << 16, 3 << 16, 5 << 16}, rb.ToArray())
+
+	// the containers of other inserted in rb are copies
+	rb.Remove(1 << 16)
+	rb.Add(1<<16 + 1)
+	assert.Equal(t, []uint32{2, 1 << 16, 5 << 16}, other.ToArray())
+}
@@ -18,6 +18,8 @@ type container interface {
 	clone() container
 	and(container) container
 	andCardinality(container) int
+	andNotCardinality(container) int
+	xorCardinality(container) int
 	iand(container) container // i stands for inplace
 	andNot(container) container
 	iandNot(container) container // i stands for inplace
@@ -46,6 +48,22 @@ type container interface {
 	maximum() uint16
 	minimum() uint16
 
+	// nextValue returns the smallest value >= x, or -1 if there is none.
+	nextValue(x uint16) int
+	// previousValue returns the largest value <= x, or -1 if there is none.
+	previousValue(x uint16) int
+	// nextAbsentValue returns the smallest value >= x that is not
+	// in the container, or -1 if there is none.
+	nextAbsentValue(x uint16) int
+	// previousAbsentValue returns the largest value <= x that is not
+	// in the container, or -1 if there is none.
+	previousAbsentValue(x uint16) int
+	// rangeCardinality returns the number of values in [start, end).
+	rangeCardinality(start, end int) int
+	// validate checks the internal invariants of the container and returns
+	// an error describing the first one that does not hold.
+	validate() error
+
 	// equals is now logical equals; it does not require the
 	// same underlying container types, but compares across
 	// any of the implementations.
@@ -566,7 +584,9 @@ func (ra *roaringArray) toBytes() ([]byte, error) {
 	return buf.Bytes(), err
 }
 
-func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
+// readFrom reads a serialized bitmap from stream, respecting the limits of
+// opts which may be nil.
+func (ra *roaringArray) readFrom(stream byteInput, opts *DeserializationOptions) (int64, error) {
 	cookie, err := stream.readUInt32()
 
 	if err != nil {
@@ -598,6 +618,12 @@ func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
 	if size > (1 << 16) {
 		return stream.getReadBytes(), fmt.Errorf("it is logically impossible to have more than (1<<16) containers")
 	}
+	if err := opts.checkContainers(uint64(size)); err != nil {
+		return stream.getReadBytes(), err
+	}
+	if err := opts.checkBytes(uint64(stream.getReadBytes()) + 4*uint64(size)); err != nil {
+		return stream.getReadBytes(), err
+	}
 
 	// descriptive header
 	buf, err := stream.next(2 * 2 * int(size))
@@ -608,6 +634,38 @@ func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
 
 	keycard := byteSliceAsUint16Slice(buf)
 
+	// expected is the number of bytes of the input known to be required
+	var expected uint64
+	// totalCard is the number of values known to be in the input
+	var totalCard uint64
+	if opts != nil {
+		// check the limits that can be derived from the header; the
+		// cardinality of a run container is only known from its runs
+		expected = uint64(stream.getReadBytes())
+		if isRunBitmap == nil || size >= noOffsetThreshold {
+			expected += 4 * uint64(size)
+		}
+		for i := uint32(0); i < size; i++ {
+			c := uint64(keycard[2*i+1]) + 1
+			if isRunBitmap != nil && isRunBitmap[i/8]&(1<<(i%8)) != 0 {
+				expected += 2
+				continue
+			}
+			totalCard += c
+			if c > arrayDefaultMaxSize {
+				expected += arrayDefaultMaxSize * 2
+			} else {
+				expected += 2 * c
+			}
+		}
+		if err := opts.checkCardinality(totalCard); err != nil {
+			return stream.getReadBytes(), err
+		}
+		if err := opts.checkBytes(expected); err != nil {
+			return stream.getReadBytes(), err
+		}
+	}
+
 	if isRunBitmap == nil || size >= noOffsetThreshold {
 		if err := stream.skipBytes(int(size) * 4); err != nil {
 			return stream.getReadBytes(), fmt.Errorf("failed to skip bytes: %s", err)
@@ -647,6 +705,11 @@ func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
 				return 0, fmt.Errorf("failed to read runtime container size: %s", err)
 			}
 
+			expected += 4 * uint64(nr)
+			if err := opts.checkBytes(expected); err != nil {
+				return stream.getReadBytes(), err
+			}
+
 			buf, err := stream.next(int(nr) * 4)
 
 			if err != nil {
@@ -658,6 +721,16 @@ func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
 				card: int64(card),
 			}
 
+			if opts != nil && opts.MaxCardinality > 0 {
+				// the header cardinality of a run container is not to be trusted
+				for _, iv := range nb.iv {
+					totalCard += uint64(iv.length) + 1
+				}
+				if err := opts.checkCardinality(totalCard); err != nil {
+					return stream.getReadBytes(), err
+				}
+			}
+
 			ra.containers[i] = &nb
 		} else if card > arrayDefaultMaxSize {
 			// bitmap container
@@ -692,6 +765,26 @@ func (ra *roaringArray) readFrom(stream byteInput) (int64, error) {
 	return stream.getReadBytes(), nil
 }
 
+// validate checks the invariants of the array and of its containers.
+func (ra *roaringArray) validate() error {
+	if len(ra.keys) != len(ra.containers) || len(ra.keys) != len(ra.needCopyOnWrite) {
+		return fmt.Errorf("invalid bitmap: %d keys, %d containers and %d copy-on-write flags",
+			len(ra.keys), len(ra.containers), len(ra.needCopyOnWrite))
+	}
+	for i, c := range ra.containers {
+		if i > 0 && ra.keys[i-1] >= ra.keys[i] {
+			return fmt.Errorf("invalid bitmap: keys are not strictly increasing at index %d", i)
+		}
+		if c == nil {
+			return fmt.Errorf("invalid bitmap: missing container for key %d", ra.keys[i])
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("invalid bitmap: container for key %d: %s", ra.keys[i], err)
+		}
+	}
+	return nil
+}
+
 func (ra *roaringArray) hasRunCompression() bool {
 	for _, c := range ra.containers {
 		switch c.(type) {
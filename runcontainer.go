@@ -2182,6 +2182,14 @@ func (rc *runContainer16) orCardinality(a container) int {
 	panic("unsupported container type")
 }
 
+func (rc *runContainer16) andNotCardinality(a container) int {
+	return rc.getCardinality() - rc.andCardinality(a)
+}
+
+func (rc *runContainer16) xorCardinality(a container) int {
+	return rc.getCardinality() + a.getCardinality() - 2*rc.andCardinality(a)
+}
+
 // orBitmapContainer finds the union of rc and bc.
 func (rc *runContainer16) orBitmapContainer(bc *bitmapContainer) container {
 	bc2 := newBitmapContainerFromRun(rc)
@@ -2381,6 +2389,75 @@ func (rc *runContainer16) selectInt(x uint16) int {
 	return rc.selectInt16(x)
 }
 
+func (rc *runContainer16) nextValue(x uint16) int {
+	w, present, _ := rc.search(int64(x), nil)
+	if present {
+		return int(x)
+	}
+	if w+1 < int64(len(rc.iv)) {
+		return int(rc.iv[w+1].start)
+	}
+	return -1
+}
+
+func (rc *runContainer16) previousValue(x uint16) int {
+	w, present, _ := rc.search(int64(x), nil)
+	if present {
+		return int(x)
+	}
+	if w >= 0 {
+		return int(rc.iv[w].last())
+	}
+	return -1
+}
+
+func (rc *runContainer16) nextAbsentValue(x uint16) int {
+	w, present, _ := rc.search(int64(x), nil)
+	if !present {
+		return int(x)
+	}
+	// runs are normally separated by at least one absent value,
+	// but we do not rely on it
+	for ; w+1 < int64(len(rc.iv)) && int64(rc.iv[w+1].start) == int64(rc.iv[w].last())+1; w++ {
+	}
+	if rc.iv[w].last() == MaxUint16 {
+		return -1
+	}
+	return int(rc.iv[w].last()) + 1
+}
+
+func (rc *runContainer16) previousAbsentValue(x uint16) int {
+	w, present, _ := rc.search(int64(x), nil)
+	if !present {
+		return int(x)
+	}
+	for ; w > 0 && int64(rc.iv[w-1].last())+1 == int64(rc.iv[w].start); w-- {
+	}
+	if rc.iv[w].start == 0 {
+		return -1
+	}
+	return int(rc.iv[w].start) - 1
+}
+
+func (rc *runContainer16) rangeCardinality(start, end int) int {
+	if start >= end {
+		return 0
+	}
+	w, _, _ := rc.search(int64(start), nil)
+	if w < 0 {
+		w = 0
+	}
+	answer := 0
+	for ; w < int64(len(rc.iv)) && int(rc.iv[w].start) < end; w++ {
+		lo := maxOfInt(int(rc.iv[w].start), start)
+		hi := minOfInt(int(rc.iv[w].last())+1, end)
+		if lo < hi {
+			answer += hi - lo
+		}
+	}
+	return answer
+}
+
 func (rc *runContainer16) andNotRunContainer16(b *runContainer16) container {
 	return rc.AndNotRunContainer16(b)
 }
@@ -2565,3 +2642,23 @@ func (rc *runContainer16) addOffset(x uint16) []container {
 	}
 	return []container{low, high}
 }
+
+func (rc *runContainer16) validate() error {
+	if len(rc.iv) == 0 {
+		return fmt.Errorf("run container is empty")
+	}
+	var card int64
+	for i, iv := range rc.iv {
+		if int(iv.start)+int(iv.length) > MaxUint16 {
+			return fmt.Errorf("run %d of run container exceeds the container range", i)
+		}
+		if i > 0 && iv.start <= rc.iv[i-1].last() {
+			return fmt.Errorf("runs of run container overlap or are not sorted at index %d", i)
+		}
+		card += iv.runlen()
+	}
+	if rc.card > 0 && rc.card != card {
+		return fmt.Errorf("run container cardinality is %d but its runs hold %d values", rc.card, card)
+	}
+	return nil
+}
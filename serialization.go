@@ -2,6 +2,7 @@ package roaring
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/tinylib/msgp/msgp"
@@ -32,3 +33,55 @@ func (b *runContainer16) readFromMsgpack(stream io.Reader) (int, error) {
 	err := msgp.Decode(stream, b)
 	return 0, err
 }
+
+// DeserializationOptions bounds the resources used to deserialize a bitmap,
+// see ReadFromWithOptions and FromBufferWithOptions. A zero limit means no
+// limit. The limits are checked against the headers of the serialized
+// bitmap before the corresponding memory is allocated.
+type DeserializationOptions struct {
+	// MaxContainers is the maximal number of containers.
+	MaxContainers uint64
+	// MaxBytes is the maximal number of bytes read from the input.
+	MaxBytes uint64
+	// MaxCardinality is the maximal number of values.
+	MaxCardinality uint64
+	// Validate checks the resulting bitmap like Bitmap.Validate.
+	Validate bool
+}
+
+// DeserializationLimitError is the error returned when the deserialization
+// of a bitmap would exceed one of the limits of DeserializationOptions.
+type DeserializationLimitError struct {
+	// Limit is the name of the exceeded limit, i.e., "MaxContainers",
+	// "MaxBytes" or "MaxCardinality".
+	Limit string
+	// Value is the amount required by the input, as far as it was read.
+	Value uint64
+	// Max is the configured limit.
+	Max uint64
+}
+
+func (e *DeserializationLimitError) Error() string {
+	return fmt.Sprintf("deserialization limit exceeded: %s is %d but the input requires at least %d", e.Limit, e.Max, e.Value)
+}
+
+func (opts *DeserializationOptions) checkContainers(n uint64) error {
+	if opts != nil && opts.MaxContainers > 0 && n > opts.MaxContainers {
+		return &DeserializationLimitError{"MaxContainers", n, opts.MaxContainers}
+	}
+	return nil
+}
+
+func (opts *DeserializationOptions) checkBytes(n uint64) error {
+	if opts != nil && opts.MaxBytes > 0 && n > opts.MaxBytes {
+		return &DeserializationLimitError{"MaxBytes", n, opts.MaxBytes}
+	}
+	return nil
+}
+
+func (opts *DeserializationOptions) checkCardinality(n uint64) error {
+	if opts != nil && opts.MaxCardinality > 0 && n > opts.MaxCardinality {
+		return &DeserializationLimitError{"MaxCardinality", n, opts.MaxCardinality}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package roaring
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The frozen format follows the one used by CRoaring
+// (roaring_bitmap_frozen_serialize / roaring_bitmap_frozen_view):
+//
+//  -- (beginning should be aligned by 32 bytes) --
+//  <bitset_data> uint64[1024 * number of bitmap containers]
+//  <run_data>    interval16[total number of runs in all run containers]
+//  <array_data>  uint16[total number of values in all array containers]
+//  <keys>        uint16[number of containers]
+//  <counts>      uint16[number of containers]
+//  <typecodes>   uint8[number of containers]
+//  <header>      uint32
+//
+// The header combines frozenCookie (15 bits) and the number of containers
+// (17 bits). For bitmap and array containers the count is the cardinality
+// minus one, for run containers it is the number of runs.
+//
+// All payloads come first so that they keep their natural alignment when
+// the buffer itself is aligned, and so that the metadata needed to set up
+// a view sits in a single contiguous block at the end of the buffer.
+
+const frozenCookie = 13766
+
+const (
+	frozenBitmapTypecode = 1
+	frozenArrayTypecode  = 2
+	frozenRunTypecode    = 3
+)
+
+var (
+	// ErrFrozenInvalidCookie is returned when the header does not carry the frozen cookie.
+	ErrFrozenInvalidCookie = errors.New("header does not contain the frozen cookie")
+	// ErrFrozenBigEndian is returned when the buffer holds a big endian frozen bitmap.
+	ErrFrozenBigEndian = errors.New("loading big endian frozen bitmaps is not supported")
+	// ErrFrozenIncomplete is returned when the buffer is too small for its header.
+	ErrFrozenIncomplete = errors.New("input buffer too small to contain a frozen bitmap")
+	// ErrFrozenOverpopulated is returned when the header claims more than (1<<16) containers.
+	ErrFrozenOverpopulated = errors.New("too many containers in frozen bitmap")
+	// ErrFrozenUnexpectedData is returned when the buffer holds more data than described by its header.
+	ErrFrozenUnexpectedData = errors.New("spurious data in frozen bitmap input")
+	// ErrFrozenInvalidTypecode is returned for an unknown container typecode.
+	ErrFrozenInvalidTypecode = errors.New("unrecognized typecode in frozen bitmap")
+	// ErrFrozenBufferTooSmall is returned by FreezeTo when the destination is too small.
+	ErrFrozenBufferTooSmall = errors.New("buffer too small to hold the frozen bitmap")
+	// ErrFrozenUnsortedKeys is returned when the container keys are not in strictly ascending order.
+	ErrFrozenUnsortedKeys = errors.New("keys in frozen bitmap are not sorted")
+)
+
+// GetFrozenSizeInBytes returns the number of bytes written by Freeze or FreezeTo.
+func (rb *Bitmap) GetFrozenSizeInBytes() uint64 {
+	return rb.highlowcontainer.frozenSizeInBytes()
+}
+
+// Freeze serializes the bitmap in the frozen format, see FrozenView.
+func (rb *Bitmap) Freeze() ([]byte, error) {
+	buf := make([]byte, rb.GetFrozenSizeInBytes())
+	_, err := rb.FreezeTo(buf)
+	return buf, err
+}
+
+// FreezeTo serializes the bitmap in the frozen format into buf and returns
+// the number of bytes written. The buffer must hold at least
+// GetFrozenSizeInBytes bytes.
+func (rb *Bitmap) FreezeTo(buf []byte) (int, error) {
+	return rb.highlowcontainer.freezeTo(buf)
+}
+
+// FrozenView turns the bitmap into a read-only view over buf, which must
+// hold exactly one bitmap in the frozen format (as written by Freeze, or
+// by CRoaring's roaring_bitmap_frozen_serialize).
+//
+// Unlike FromBuffer, no container payload is parsed and the containers
+// are allocated in bulk, so that setting up the view costs a handful of
+// allocations regardless of the number of containers. This makes it
+// suitable for memory-mapped files. For best performance, buf should be
+// aligned on 32 bytes, which is always the case for the start of a mapping.
+//
+// The same rules as for FromBuffer apply: buf must not be modified while
+// the view is in use, and modifying the view makes copies of the affected
+// containers (copy-on-write) instead of writing to buf.
+func (rb *Bitmap) FrozenView(buf []byte) error {
+	return rb.highlowcontainer.frozenView(buf)
+}
+
+func (ra *roaringArray) frozenSizeInBytes() uint64 {
+	nbytes := uint64(4)
+	for _, c := range ra.containers {
+		switch x := c.(type) {
+		case *bitmapContainer:
+			nbytes += 8 * uint64(len(x.bitmap))
+		case *arrayContainer:
+			nbytes += 2 * uint64(len(x.content))
+		case *runContainer16:
+			nbytes += 4 * uint64(len(x.iv))
+		}
+		// key, count and typecode
+		nbytes += 2 + 2 + 1
+	}
+	return nbytes
+}
+
+func (ra *roaringArray) freezeTo(buf []byte) (int, error) {
+	size := int(ra.frozenSizeInBytes())
+	if len(buf) < size {
+		return 0, ErrFrozenBufferTooSmall
+	}
+	buf = buf[:size]
+	nCont := len(ra.containers)
+
+	bitsetOffset := 0
+	runOffset, arrayOffset := 0, 0
+	for _, c := range ra.containers {
+		switch x := c.(type) {
+		case *bitmapContainer:
+			runOffset += 8 * len(x.bitmap)
+		case *runContainer16:
+			arrayOffset += 4 * len(x.iv)
+		}
+	}
+	arrayOffset += runOffset
+	keysOffset := size - 4 - 5*nCont
+	countsOffset := keysOffset + 2*nCont
+	typesOffset := countsOffset + 2*nCont
+
+	for i, c := range ra.containers {
+		var count uint16
+		var typecode byte
+		switch x := c.(type) {
+		case *bitmapContainer:
+			bitsetOffset += copy(buf[bitsetOffset:], uint64SliceAsByteSlice(x.bitmap))
+			count = uint16(x.getCardinality() - 1)
+			typecode = frozenBitmapTypecode
+		case *arrayContainer:
+			arrayOffset += copy(buf[arrayOffset:], uint16SliceAsByteSlice(x.content))
+			count = uint16(len(x.content) - 1)
+			typecode = frozenArrayTypecode
+		case *runContainer16:
+			for _, iv := range x.iv {
+				binary.LittleEndian.PutUint16(buf[runOffset:], iv.start)
+				binary.LittleEndian.PutUint16(buf[runOffset+2:], iv.length)
+				runOffset += 4
+			}
+			count = uint16(len(x.iv))
+			typecode = frozenRunTypecode
+		}
+		binary.LittleEndian.PutUint16(buf[keysOffset+2*i:], ra.keys[i])
+		binary.LittleEndian.PutUint16(buf[countsOffset+2*i:], count)
+		buf[typesOffset+i] = typecode
+	}
+	binary.LittleEndian.PutUint32(buf[size-4:], uint32(frozenCookie)|uint32(nCont)<<15)
+	return size, nil
+}
+
+func (ra *roaringArray) frozenView(buf []byte) error {
+	if len(buf) < 4 {
+		return ErrFrozenIncomplete
+	}
+
+	if binary.BigEndian.Uint32(buf[len(buf)-4:])&0x7fff == frozenCookie {
+		return ErrFrozenBigEndian
+	}
+	header := binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if header&0x7fff != frozenCookie {
+		return ErrFrozenInvalidCookie
+	}
+	buf = buf[:len(buf)-4]
+
+	nCont := int(header >> 15)
+	if nCont > (1 << 16) {
+		return ErrFrozenOverpopulated
+	}
+	// 2 bytes per key, 2 bytes per count, 1 byte per typecode
+	if len(buf) < 5*nCont {
+		return ErrFrozenIncomplete
+	}
+
+	types := buf[len(buf)-nCont:]
+	buf = buf[:len(buf)-nCont]
+	counts := byteSliceAsUint16Slice(buf[len(buf)-2*nCont:])
+	buf = buf[:len(buf)-2*nCont]
+	keys := byteSliceAsUint16Slice(buf[len(buf)-2*nCont:])
+	buf = buf[:len(buf)-2*nCont]
+
+	nBitmap, nArray, nRun := 0, 0, 0
+	nArrayEl, nRunEl := 0, 0
+	for i, t := range types {
+		switch t {
+		case frozenBitmapTypecode:
+			nBitmap++
+		case frozenArrayTypecode:
+			nArray++
+			nArrayEl += int(counts[i]) + 1
+		case frozenRunTypecode:
+			nRun++
+			nRunEl += int(counts[i])
+		default:
+			return ErrFrozenInvalidTypecode
+		}
+	}
+
+	bitsetBytes := 8 * (1 << 10) * nBitmap
+	if len(buf) < bitsetBytes+4*nRunEl+2*nArrayEl {
+		return ErrFrozenIncomplete
+	}
+	if len(buf) > bitsetBytes+4*nRunEl+2*nArrayEl {
+		return ErrFrozenUnexpectedData
+	}
+	for i := 1; i < nCont; i++ {
+		if keys[i] <= keys[i-1] {
+			return ErrFrozenUnsortedKeys
+		}
+	}
+
+	bitsetData := byteSliceAsUint64Slice(buf[:bitsetBytes])
+	buf = buf[bitsetBytes:]
+	runData := byteSliceAsInterval16Slice(buf[:4*nRunEl])
+	buf = buf[4*nRunEl:]
+	arrayData := byteSliceAsUint16Slice(buf)
+
+	// one allocation per container type, rather than one per container
+	bitmaps := make([]bitmapContainer, nBitmap)
+	arrays := make([]arrayContainer, nArray)
+	runs := make([]runContainer16, nRun)
+	containers := make([]container, nCont)
+	needCopyOnWrite := make([]bool, nCont)
+
+	iBitmap, iArray, iRun := 0, 0, 0
+	for i, t := range types {
+		needCopyOnWrite[i] = true
+		switch t {
+		case frozenBitmapTypecode:
+			bitmaps[iBitmap].cardinality = int(counts[i]) + 1
+			bitmaps[iBitmap].bitmap = bitsetData[:1024:1024]
+			bitsetData = bitsetData[1024:]
+			containers[i] = &bitmaps[iBitmap]
+			iBitmap++
+		case frozenArrayTypecode:
+			n := int(counts[i]) + 1
+			arrays[iArray].content = arrayData[:n:n]
+			arrayData = arrayData[n:]
+			containers[i] = &arrays[iArray]
+			iArray++
+		case frozenRunTypecode:
+			n := int(counts[i])
+			runs[iRun].iv = runData[:n:n]
+			runData = runData[n:]
+			// computed eagerly so that concurrent readers never write the cache
+			runs[iRun].cardinality()
+			containers[i] = &runs[iRun]
+			iRun++
+		}
+	}
+
+	// the keys are copied because the array may later be modified in place
+	ra.keys = make([]uint16, nCont)
+	copy(ra.keys, keys)
+	ra.containers = containers
+	ra.needCopyOnWrite = needCopyOnWrite
+	return nil
+}
@@ -0,0 +1,162 @@
+package roaring
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func frozenTestBitmap() *Bitmap {
+	rb := NewBitmap()
+	// array container
+	rb.AddMany([]uint32{1, 2, 3, 1000, 60000})
+	// bitmap container
+	for i := uint32(0); i < 10000; i++ {
+		rb.Add(1<<16 + 3*i)
+	}
+	// run container
+	rb.AddRange(5<<16, 5<<16+30000)
+	rb.AddRange(5<<16+40000, 5<<16+40010)
+	rb.RunOptimize()
+	return rb
+}
+
+func TestFrozenViewRoundTrip(t *testing.T) {
+	rb := frozenTestBitmap()
+	stats := rb.Stats()
+	require.EqualValues(t, 1, stats.ArrayContainers)
+	require.EqualValues(t, 1, stats.BitmapContainers)
+	require.EqualValues(t, 1, stats.RunContainers)
+
+	buf, err := rb.Freeze()
+	require.NoError(t, err)
+	assert.EqualValues(t, rb.GetFrozenSizeInBytes(), len(buf))
+
+	view := NewBitmap()
+	require.NoError(t, view.FrozenView(buf))
+
+	assert.True(t, rb.Equals(view))
+	assert.Equal(t, rb.GetCardinality(), view.GetCardinality())
+	assert.Equal(t, rb.ToArray(), view.ToArray())
+	assert.True(t, view.Contains(1<<16+3))
+	assert.False(t, view.Contains(1<<16+4))
+	assert.Equal(t, rb.Rank(5<<16+100), view.Rank(5<<16+100))
+
+	for _, i := range []uint32{0, 4, 5000, 10004, 40000} {
+		expected, err := rb.Select(i)
+		require.NoError(t, err)
+		actual, err := view.Select(i)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	other := BitmapOf(2, 1<<16+3, 5<<16+7, 7<<16)
+	assert.True(t, And(rb, other).Equals(And(view, other)))
+	assert.True(t, Or(rb, other).Equals(Or(view, other)))
+}
+
+func TestFrozenViewIsCopyOnWrite(t *testing.T) {
+	rb := frozenTestBitmap()
+	buf, err := rb.Freeze()
+	require.NoError(t, err)
+
+	orig := make([]byte, len(buf))
+	copy(orig, buf)
+
+	view := NewBitmap()
+	require.NoError(t, view.FrozenView(buf))
+
+	view.Add(4)
+	view.Remove(1<<16 + 3)
+	view.RemoveRange(5<<16, 5<<16+10)
+	view.Add(9 << 16)
+
+	assert.Equal(t, orig, buf)
+	assert.True(t, view.Contains(4))
+	assert.False(t, view.Contains(1<<16+3))
+	assert.False(t, rb.Contains(4))
+
+	again := NewBitmap()
+	require.NoError(t, again.FrozenView(buf))
+	assert.True(t, rb.Equals(again))
+}
+
+func TestFrozenViewEmpty(t *testing.T) {
+	buf, err := NewBitmap().Freeze()
+	require.NoError(t, err)
+	assert.Len(t, buf, 4)
+
+	view := BitmapOf(1, 2, 3)
+	require.NoError(t, view.FrozenView(buf))
+	assert.True(t, view.IsEmpty())
+}
+
+func TestFrozenViewFormat(t *testing.T) {
+	// {1, 2, 3} laid out as specified by CRoaring's frozen format
+	expected := []byte{
+		1, 0, 2, 0, 3, 0, // array data
+		0, 0, // keys
+		2, 0, // counts
+		2,                // typecodes
+		0xc6, 0xb5, 0, 0, // header: 13766 | 1<<15
+	}
+
+	buf, err := BitmapOf(1, 2, 3).Freeze()
+	require.NoError(t, err)
+	assert.Equal(t, expected, buf)
+}
+
+func TestFrozenViewRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for trial := 0; trial < 20; trial++ {
+		rb := NewBitmap()
+		n := r.Intn(100000)
+		for i := 0; i < n; i++ {
+			rb.Add(uint32(r.Intn(1 << 22)))
+		}
+		if trial%2 == 0 {
+			rb.RunOptimize()
+		}
+
+		buf := make([]byte, rb.GetFrozenSizeInBytes())
+		written, err := rb.FreezeTo(buf)
+		require.NoError(t, err)
+		assert.Equal(t, len(buf), written)
+
+		view := NewBitmap()
+		require.NoError(t, view.FrozenView(buf))
+		assert.True(t, rb.Equals(view))
+	}
+}
+
+func TestFrozenViewErrors(t *testing.T) {
+	buf, err := frozenTestBitmap().Freeze()
+	require.NoError(t, err)
+
+	rb := NewBitmap()
+	assert.Equal(t, ErrFrozenIncomplete, rb.FrozenView(buf[:3]))
+	assert.Equal(t, ErrFrozenIncomplete, rb.FrozenView(buf[len(buf)-8:]))
+	assert.Equal(t, ErrFrozenUnexpectedData, rb.FrozenView(append([]byte{0, 0}, buf...)))
+
+	_, err = frozenTestBitmap().FreezeTo(make([]byte, 10))
+	assert.Equal(t, ErrFrozenBufferTooSmall, err)
+
+	bad := append([]byte(nil), buf...)
+	bad[len(bad)-4] ^= 1
+	assert.Equal(t, ErrFrozenInvalidCookie, rb.FrozenView(bad))
+
+	bad = append([]byte(nil), buf...)
+	bad[len(bad)-5] = 9
+	assert.Equal(t, ErrFrozenInvalidTypecode, rb.FrozenView(bad))
+
+	bigEndian := []byte{0, 0, frozenCookie >> 8, frozenCookie & 0xff}
+	assert.Equal(t, ErrFrozenBigEndian, rb.FrozenView(bigEndian))
+
+	// the keys of the 3 containers sit before the counts, typecodes and header: make the second one 0
+	bad = append([]byte(nil), buf...)
+	bad[len(bad)-4-3-2*3-2*3+2] = 0
+	bad[len(bad)-4-3-2*3-2*3+3] = 0
+	assert.Equal(t, ErrFrozenUnsortedKeys, rb.FrozenView(bad))
+}
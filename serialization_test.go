@@ -771,3 +771,208 @@ func BenchmarkUnserializeFromBuffer(b *testing.B) {
 		})
 	}
 }
+
+func TestStrictDeserializationOfCrashProneInput(t *testing.T) {
+	files, err := filepath.Glob("testdata/crash*.bin")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+
+		rb := NewBitmap()
+		if _, err := rb.FromBufferStrict(buf); err != nil {
+			assert.True(t, rb.IsEmpty(), file)
+		} else {
+			assert.NoError(t, rb.Validate(), file)
+		}
+
+		rb = NewBitmap()
+		if _, err := rb.ReadFromStrict(bytes.NewReader(buf)); err != nil {
+			assert.True(t, rb.IsEmpty(), file)
+		}
+	}
+}
+
+func TestStrictDeserializationRejectsCorruptContainers(t *testing.T) {
+	rb := BitmapOf(1, 5, 9, 1<<16)
+	for i := uint32(0); i < 10000; i++ {
+		rb.Add(2<<16 + 2*i)
+	}
+	rb.AddRange(3<<16, 3<<16+100)
+	rb.AddRange(3<<16+200, 3<<16+300)
+	rb.RunOptimize()
+	buf, err := rb.ToBytes()
+	assert.NoError(t, err)
+
+	valid := NewBitmap()
+	_, err = valid.FromBufferStrict(buf)
+	assert.NoError(t, err)
+	assert.True(t, rb.Equals(valid))
+
+	// header: cookie (4 bytes), run flags (1 byte), then key and cardinality-1
+	// per container, then offsets of the four containers
+	const header = 5
+	const data = header + 16 + 16
+	corruptions := map[string]func(b []byte){
+		"unsorted keys": func(b []byte) {
+			binary.LittleEndian.PutUint16(b[header+4:], 5)
+		},
+		"unsorted array": func(b []byte) {
+			binary.LittleEndian.PutUint16(b[data+2:], 0)
+		},
+		"wrong bitmap cardinality": func(b []byte) {
+			binary.LittleEndian.PutUint16(b[header+10:], 9000)
+		},
+		"overlapping runs": func(b []byte) {
+			// second run of the last container starts within the first one
+			binary.LittleEndian.PutUint16(b[len(b)-4:], 50)
+		},
+		"wrong run cardinality": func(b []byte) {
+			binary.LittleEndian.PutUint16(b[header+14:], 150)
+		},
+	}
+	for name, corrupt := range corruptions {
+		bad := append([]byte(nil), buf...)
+		corrupt(bad)
+
+		rb := NewBitmap()
+		_, err := rb.FromBuffer(bad)
+		assert.NoError(t, err, name)
+		assert.Error(t, rb.Validate(), name)
+
+		_, err = rb.FromBufferStrict(bad)
+		assert.Error(t, err, name)
+		assert.True(t, rb.IsEmpty(), name)
+
+		_, err = rb.ReadFromStrict(bytes.NewReader(bad))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestStrictDeserializationRandomCorruption(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+	rb := NewBitmap()
+	for i := 0; i < 20000; i++ {
+		rb.Add(uint32(r.Intn(1 << 20)))
+	}
+	rb.AddRange(1<<21, 1<<21+70000)
+	rb.RunOptimize()
+	buf, err := rb.ToBytes()
+	assert.NoError(t, err)
+
+	for trial := 0; trial < 1000; trial++ {
+		bad := append([]byte(nil), buf...)
+		for i := 0; i < 1+r.Intn(4); i++ {
+			bad[r.Intn(len(bad))] = byte(r.Intn(256))
+		}
+		decoded := NewBitmap()
+		if _, err := decoded.FromBufferStrict(bad); err != nil {
+			continue
+		}
+		// a bitmap that passed validation must be usable
+		assert.Equal(t, int(decoded.GetCardinality()), len(decoded.ToArray()))
+		decoded.Or(rb)
+		decoded.And(rb)
+	}
+}
+
+func TestDeserializationOptions(t *testing.T) {
+	rb := BitmapOf(1, 5, 9)
+	for i := uint32(0); i < 10000; i++ {
+		rb.Add(1<<16 + 3*i)
+	}
+	rb.AddRange(2<<16, 2<<16+1000)
+	rb.RunOptimize()
+	buf, err := rb.ToBytes()
+	assert.NoError(t, err)
+	card := rb.GetCardinality()
+
+	decode := func(opts DeserializationOptions) (*Bitmap, *Bitmap, error) {
+		fromBuffer := NewBitmap()
+		_, err1 := fromBuffer.FromBufferWithOptions(buf, opts)
+		fromReader := NewBitmap()
+		_, err2 := fromReader.ReadFromWithOptions(bytes.NewReader(buf), opts)
+		assert.Equal(t, err1, err2)
+		return fromBuffer, fromReader, err1
+	}
+
+	for _, opts := range []DeserializationOptions{
+		{},
+		{MaxContainers: 3, MaxBytes: uint64(len(buf)), MaxCardinality: card, Validate: true},
+	} {
+		fromBuffer, fromReader, err := decode(opts)
+		assert.NoError(t, err)
+		assert.True(t, rb.Equals(fromBuffer))
+		assert.True(t, rb.Equals(fromReader))
+	}
+
+	limits := []struct {
+		opts  DeserializationOptions
+		limit string
+	}{
+		{DeserializationOptions{MaxContainers: 2}, "MaxContainers"},
+		{DeserializationOptions{MaxBytes: uint64(len(buf)) - 1}, "MaxBytes"},
+		{DeserializationOptions{MaxBytes: 20}, "MaxBytes"},
+		{DeserializationOptions{MaxCardinality: card - 1}, "MaxCardinality"},
+	}
+	for _, l := range limits {
+		fromBuffer, fromReader, err := decode(l.opts)
+		limitErr, ok := err.(*DeserializationLimitError)
+		if assert.True(t, ok, "%v", err) {
+			assert.Equal(t, l.limit, limitErr.Limit)
+			assert.True(t, limitErr.Value > limitErr.Max)
+		}
+		assert.True(t, fromBuffer.IsEmpty())
+		assert.True(t, fromReader.IsEmpty())
+	}
+}
+
+func TestDeserializationOptionsCheckBeforeAllocating(t *testing.T) {
+	// a header announcing 65536 full bitmap containers, without their content
+	header := make([]byte, 8+4*(1<<16))
+	binary.LittleEndian.PutUint32(header, serialCookieNoRunContainer)
+	binary.LittleEndian.PutUint32(header[4:], 1<<16)
+	for i := 0; i < 1<<16; i++ {
+		binary.LittleEndian.PutUint16(header[8+4*i:], uint16(i))
+		binary.LittleEndian.PutUint16(header[8+4*i+2:], MaxUint16)
+	}
+
+	opts := DeserializationOptions{MaxBytes: 1 << 20}
+	var err error
+	allocs := testing.AllocsPerRun(5, func() {
+		_, err = NewBitmap().ReadFromWithOptions(bytes.NewReader(header), opts)
+	})
+	assert.IsType(t, &DeserializationLimitError{}, err)
+	assert.Equal(t, "MaxBytes", err.(*DeserializationLimitError).Limit)
+	// only the header is read, no container is allocated
+	assert.True(t, allocs < 10, "%v allocations", allocs)
+
+	_, err = NewBitmap().FromBufferWithOptions(header, DeserializationOptions{MaxContainers: 1000})
+	assert.Equal(t, "MaxContainers", err.(*DeserializationLimitError).Limit)
+	_, err = NewBitmap().FromBufferWithOptions(header, DeserializationOptions{MaxCardinality: 1 << 20})
+	assert.Equal(t, "MaxCardinality", err.(*DeserializationLimitError).Limit)
+}
+
+func TestDeserializationOptionsRunCardinality(t *testing.T) {
+	rb := NewBitmap()
+	rb.AddRange(0, 1<<16)
+	rb.RunOptimize()
+	buf, err := rb.ToBytes()
+	assert.NoError(t, err)
+	// the header claims a single value, the run holds 65536 of them
+	binary.LittleEndian.PutUint16(buf[7:], 0)
+
+	_, err = NewBitmap().FromBufferWithOptions(buf, DeserializationOptions{MaxCardinality: 100})
+	if assert.IsType(t, &DeserializationLimitError{}, err) {
+		limitErr := err.(*DeserializationLimitError)
+		assert.Equal(t, "MaxCardinality", limitErr.Limit)
+		assert.EqualValues(t, 1<<16, limitErr.Value)
+	}
+	_, err = NewBitmap().ReadFromWithOptions(bytes.NewReader(buf), DeserializationOptions{MaxCardinality: 100})
+	assert.IsType(t, &DeserializationLimitError{}, err)
+
+	_, err = NewBitmap().FromBufferWithOptions(buf, DeserializationOptions{MaxCardinality: 1 << 16})
+	assert.NoError(t, err)
+}